@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// getAuditLogHandler returns the entries of the audit log, newest first. They can be filtered by
+// actor, action, entity_type, entity_id and a from/to time range.
+func (app *application) getAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	var q model.AuditQuery
+	var filters model.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	q.ActorID = int64(app.readInt(qs, "actor", 0, v))
+	q.Action = app.readStrings(qs, "action", "")
+	q.EntityType = app.readStrings(qs, "entity_type", "")
+	q.EntityID = int64(app.readInt(qs, "entity_id", 0, v))
+	q.From = app.readTime(qs, "from", time.Time{}, v)
+	q.To = app.readTime(qs, "to", time.Time{}, v)
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readStrings(qs, "sort", "-id")
+	filters.SortSafeList = []string{"id", "-id"}
+
+	model.ValidateAuditQuery(v, q)
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Audit.GetAll(q, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"audit": entries, "metadata": metadata}, nil)
+}
+
+// auditEntry returns the audit log entry of a change the request is about to make, with who
+// makes it and from where. before is the entity before the change, nil when it doesn't exist yet.
+// The entry is passed to the model method making the change, which records it in the same
+// transaction, so a change that can't be recorded is not made and the request fails.
+func (app *application) auditEntry(r *http.Request, action, entityType string, before interface{}) *model.AuditEntry {
+	entry := &model.AuditEntry{
+		Action:     action,
+		EntityType: entityType,
+		RequestID:  app.contextGetRequestID(r),
+		IP:         remoteIP(r),
+		Before:     before,
+	}
+
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		entry.ActorID = &user.ID
+	}
+
+	return entry
+}
+
+// remoteIP returns the IP address the request came from. The server is not behind a trusted
+// proxy, so forwarding headers are not looked at.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if net.ParseIP(host) == nil {
+		return ""
+	}
+
+	return host
+}
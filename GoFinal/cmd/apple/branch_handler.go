@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// branchInput is the request body of the branch create and update endpoints.
+type branchInput struct {
+	Address   string                `json:"address"`
+	Latitude  *float64              `json:"latitude"`
+	Longitude *float64              `json:"longitude"`
+	Phone     string                `json:"phone"`
+	Hours     []model.OpeningPeriod `json:"hours"`
+}
+
+func (app *application) getBranchesList(w http.ResponseWriter, r *http.Request) {
+	storeID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Make sure that the store exists, so an unknown store is a 404 instead of an empty list.
+	_, err = app.models.Stores.Get(storeID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	branches, err := app.models.Branches.GetAllForStore(int64(storeID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"branches": branches}, nil)
+}
+
+func (app *application) createBranchHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input branchInput
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Branches can't be added to a store in the trash.
+	_, err = app.models.Stores.Get(storeID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	branch := &model.Branch{
+		StoreID:   int64(storeID),
+		Address:   input.Address,
+		Latitude:  input.Latitude,
+		Longitude: input.Longitude,
+		Phone:     input.Phone,
+		Hours:     input.Hours,
+	}
+
+	v := validator.New()
+
+	if model.ValidateBranch(v, branch); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Branches.Insert(branch)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"branch": branch}, nil)
+}
+
+func (app *application) getBranchHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, branchID, err := app.readBranchIDs(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	branch, err := app.models.Branches.Get(storeID, branchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"branch": branch}, nil)
+}
+
+// updateBranchHandler replaces the address, location, phone and hours of a branch.
+func (app *application) updateBranchHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, branchID, err := app.readBranchIDs(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	branch, err := app.models.Branches.Get(storeID, branchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input branchInput
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	branch.Address = input.Address
+	branch.Latitude = input.Latitude
+	branch.Longitude = input.Longitude
+	branch.Phone = input.Phone
+	branch.Hours = input.Hours
+
+	v := validator.New()
+
+	if model.ValidateBranch(v, branch); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Branches.Update(branch)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"branch": branch}, nil)
+}
+
+func (app *application) deleteBranchHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, branchID, err := app.readBranchIDs(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Branches.Delete(storeID, branchID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+// readBranchIDs reads the interpolated "id" of the store and "branchId" from the request URL.
+func (app *application) readBranchIDs(r *http.Request) (int64, int64, error) {
+	storeID, err := app.readIDParam(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	branchID, err := app.readIntParam(r, "branchId")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int64(storeID), int64(branchID), nil
+}
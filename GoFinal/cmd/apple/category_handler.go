@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// getCategoriesTree returns the whole category tree.
+func (app *application) getCategoriesTree(w http.ResponseWriter, r *http.Request) {
+	categories, err := app.models.Categories.GetTree()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"categories": categories}, nil)
+}
+
+func (app *application) createCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ParentID *int64 `json:"parentId"`
+		Title    string `json:"title"`
+		Slug     string `json:"slug"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	category := &model.Category{
+		ParentID: input.ParentID,
+		Title:    input.Title,
+		Slug:     input.Slug,
+	}
+
+	v := validator.New()
+
+	if model.ValidateCategory(v, category); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Categories.Insert(category)
+	if err != nil {
+		app.categoryErrorResponse(w, r, v, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"category": category}, nil)
+}
+
+// getCategoryHandler returns a category together with its direct subcategories.
+func (app *application) getCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	category, err := app.models.Categories.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"category": category}, nil)
+}
+
+// updateCategoryHandler renames a category or moves it below another parent. A "parentId" of
+// null makes it a top level category.
+func (app *application) updateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	category, err := app.models.Categories.Get(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		ParentID *int64 `json:"parentId"`
+		Title    string `json:"title"`
+		Slug     string `json:"slug"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	category.ParentID = input.ParentID
+	category.Title = input.Title
+	category.Slug = input.Slug
+	category.Children = nil
+
+	v := validator.New()
+
+	if model.ValidateCategory(v, category); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Categories.Update(category)
+	if err != nil {
+		app.categoryErrorResponse(w, r, v, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"category": category}, nil)
+}
+
+// deleteCategoryHandler removes a category without subcategories. Its products are kept, but lose
+// their category.
+func (app *application) deleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Categories.Delete(int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrCategoryHasChildren):
+			app.categoryHasChildrenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+// categoryErrorResponse answers a failed insert or update of a category.
+func (app *application) categoryErrorResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		v.AddError("parentId", "must be an existing category")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, model.ErrCategoryCycle):
+		v.AddError("parentId", "must not be the category itself or one of its subcategories")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, model.ErrDuplicateSlug):
+		v.AddError("slug", "a category with this slug already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkCategory adds a validation error under the "categoryId" key when the category of a product
+// doesn't exist. A nil ID means no category and is always fine.
+func (app *application) checkCategory(v *validator.Validator, id *int64) error {
+	if id == nil {
+		return nil
+	}
+
+	_, err := app.models.Categories.Get(*id)
+	if errors.Is(err, model.ErrRecordNotFound) {
+		v.AddError("categoryId", "must be an existing category")
+		return nil
+	}
+
+	return err
+}
@@ -13,6 +13,10 @@ type contextKey string
 // context.
 const userContextKey = contextKey("user")
 
+// requestIDContextKey is used as a key for getting and setting the ID of the request in the
+// request context.
+const requestIDContextKey = contextKey("request_id")
+
 // contextSetUser returns a new copy of the request with the provided User struct added to the
 // context.
 func (app *application) contextSetUser(r *http.Request, user *model.User) *http.Request {
@@ -31,3 +35,17 @@ func (app *application) contextGetUser(r *http.Request) *model.User {
 
 	return user
 }
+
+// contextSetRequestID returns a new copy of the request with the provided request ID added to the
+// context.
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID retrieves the ID of the request from the request context, or an empty
+// string if it has none.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
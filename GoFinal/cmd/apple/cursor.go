@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// errInvalidCursor is returned for a cursor that wasn't made by this server or was tampered with.
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursorIgnoredParams are the query string values that don't change which records a listing
+// returns, so a cursor can be used whatever they are.
+var cursorIgnoredParams = []string{"cursor", "page", "page_size", "facets", "currency", "count", "fields", "include"}
+
+// readCursor reads the optional "cursor" query string value, which selects the page of a listing
+// by position instead of by page number. An empty cursor selects the first page. query is the
+// fingerprint of the filters the cursor must have been made for. It returns nil if there is no
+// cursor.
+func (app *application) readCursor(qs url.Values, query string, v *validator.Validator) *model.Cursor {
+	if !qs.Has("cursor") {
+		return nil
+	}
+
+	v.Check(!qs.Has("page"), "page", "must not be used together with cursor")
+
+	s := qs.Get("cursor")
+	if s == "" {
+		return &model.Cursor{}
+	}
+
+	cursor, err := app.decodeCursor(s)
+	if err != nil {
+		v.AddError("cursor", "must be a cursor returned by this listing")
+		return &model.Cursor{}
+	}
+
+	v.Check(cursor.Query == query, "cursor", "must be used with the filters it was made for")
+
+	return cursor
+}
+
+// setCursors fills in the cursors of the pages around a page selected by cursor.
+func (app *application) setCursors(metadata *model.Metadata, query string) {
+	if metadata.Next != nil {
+		metadata.Next.Query = query
+		metadata.NextCursor = app.encodeCursor(metadata.Next)
+	}
+
+	if metadata.Prev != nil {
+		metadata.Prev.Query = query
+		metadata.PrevCursor = app.encodeCursor(metadata.Prev)
+	}
+}
+
+// cursorQuery returns the fingerprint of the filters and the sort of a listing that a cursor is
+// tied to.
+func cursorQuery(qs url.Values, sort string) string {
+	filters := url.Values{}
+	for key, values := range qs {
+		if !validator.In(key, cursorIgnoredParams...) {
+			filters[key] = values
+		}
+	}
+	filters.Set("sort", sort)
+
+	sum := sha256.Sum256([]byte(filters.Encode()))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// encodeCursor returns the opaque form of a cursor: its JSON and an HMAC-SHA256 signature of it,
+// both base64url encoded and separated by a dot.
+func (app *application) encodeCursor(cursor *model.Cursor) string {
+	// A Cursor always marshals, it only holds strings, numbers and booleans.
+	payload, _ := json.Marshal(cursor)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(app.signCursor(payload))
+}
+
+// decodeCursor checks the signature of an opaque cursor and returns the cursor.
+func (app *application) decodeCursor(s string) (*model.Cursor, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(s, ".")
+	if !ok {
+		return nil, errInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	if !hmac.Equal(signature, app.signCursor(payload)) {
+		return nil, errInvalidCursor
+	}
+
+	var cursor model.Cursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return nil, errInvalidCursor
+	}
+
+	if cursor.ID < 1 {
+		return nil, errInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+func (app *application) signCursor(payload []byte) []byte {
+	mac := hmac.New(sha256.New, app.config.cursor.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// newCursorApp returns an application signing its cursors with secret.
+func newCursorApp(secret string) *application {
+	app := &application{}
+	app.config.cursor.secret = []byte(secret)
+	return app
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	app := newCursorApp("secret")
+
+	for _, cursor := range []*model.Cursor{
+		{Sort: "price", Key: "99999", ID: 12, Query: "abc"},
+		{Sort: "-createdAt", Key: "2026-10-18T10:00:00Z", ID: 3, Backward: true, Query: "abc"},
+		{Sort: "relevance", Key: "0.0607927", ID: 1, Query: "def"},
+	} {
+		got, err := app.decodeCursor(app.encodeCursor(cursor))
+		if err != nil {
+			t.Fatalf("decodeCursor(encodeCursor(%+v)): %v", cursor, err)
+		}
+		if !reflect.DeepEqual(got, cursor) {
+			t.Errorf("decodeCursor(encodeCursor(%+v)) = %+v", cursor, got)
+		}
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	app := newCursorApp("secret")
+
+	valid := app.encodeCursor(&model.Cursor{Sort: "price", Key: "100", ID: 7, Query: "abc"})
+	payload, signature, _ := strings.Cut(valid, ".")
+	encode := base64.RawURLEncoding.EncodeToString
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "no signature", cursor: payload},
+		{name: "payload not base64", cursor: "!!!." + signature},
+		{name: "signature not base64", cursor: payload + ".!!!"},
+		{name: "changed payload", cursor: encode([]byte(`{"s":"price","k":"1","i":7,"q":"abc"}`)) + "." + signature},
+		{name: "turned backward", cursor: encode([]byte(`{"s":"price","k":"100","i":7,"b":true,"q":"abc"}`)) + "." + signature},
+		{name: "changed signature", cursor: payload + "." + encode([]byte("not the signature"))},
+		{name: "other secret", cursor: newCursorApp("other").encodeCursor(&model.Cursor{Sort: "price", Key: "100", ID: 7, Query: "abc"})},
+		{name: "signed without a record", cursor: app.encodeCursor(&model.Cursor{Sort: "price", Query: "abc"})},
+		{name: "signed, not a cursor", cursor: encode([]byte(`[1]`)) + "." + encode(app.signCursor([]byte(`[1]`)))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cursor, err := app.decodeCursor(tt.cursor); !errors.Is(err, errInvalidCursor) {
+				t.Errorf("decodeCursor(%q) = %+v, %v, want %v", tt.cursor, cursor, err, errInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestCursorQuery(t *testing.T) {
+	base := cursorQuery(url.Values{"country": {"DE"}, "priceCurrency": {"USD"}}, "price")
+
+	tests := []struct {
+		name string
+		qs   url.Values
+		sort string
+		same bool
+	}{
+		{
+			name: "paging and output parameters",
+			qs:   url.Values{"country": {"DE"}, "priceCurrency": {"USD"}, "cursor": {"x"}, "page_size": {"50"}, "fields": {"title"}, "count": {"none"}, "currency": {"EUR"}},
+			sort: "price",
+			same: true,
+		},
+		{
+			name: "sort parameter in the query string",
+			qs:   url.Values{"country": {"DE"}, "priceCurrency": {"USD"}, "sort": {"-price"}},
+			sort: "price",
+			same: true,
+		},
+		{
+			name: "other filter value",
+			qs:   url.Values{"country": {"FR"}, "priceCurrency": {"USD"}},
+			sort: "price",
+		},
+		{
+			name: "more filters",
+			qs:   url.Values{"country": {"DE"}, "priceCurrency": {"USD"}, "title": {"iPhone"}},
+			sort: "price",
+		},
+		{
+			name: "other sort",
+			qs:   url.Values{"country": {"DE"}, "priceCurrency": {"USD"}},
+			sort: "-price",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cursorQuery(tt.qs, tt.sort); (got == base) != tt.same {
+				t.Errorf("cursorQuery(%v, %q) = %q, base %q, same = %t", tt.qs, tt.sort, got, base, tt.same)
+			}
+		})
+	}
+}
+
+func TestReadCursor(t *testing.T) {
+	app := newCursorApp("secret")
+
+	filters := url.Values{"country": {"DE"}}
+	query := cursorQuery(filters, "price")
+	cursor := app.encodeCursor(&model.Cursor{Sort: "price", Key: "100", ID: 7, Query: query})
+
+	tests := []struct {
+		name    string
+		qs      url.Values
+		query   string
+		want    *model.Cursor
+		wantErr string
+	}{
+		{
+			name:  "no cursor",
+			qs:    url.Values{"page": {"2"}},
+			query: query,
+		},
+		{
+			name:  "first page",
+			qs:    url.Values{"cursor": {""}},
+			query: query,
+			want:  &model.Cursor{},
+		},
+		{
+			name:  "same filters",
+			qs:    url.Values{"cursor": {cursor}, "country": {"DE"}},
+			query: query,
+			want:  &model.Cursor{Sort: "price", Key: "100", ID: 7, Query: query},
+		},
+		{
+			name:    "other filters",
+			qs:      url.Values{"cursor": {cursor}, "country": {"FR"}},
+			query:   cursorQuery(url.Values{"country": {"FR"}}, "price"),
+			wantErr: "cursor",
+		},
+		{
+			name:    "tampered",
+			qs:      url.Values{"cursor": {cursor + "x"}},
+			query:   query,
+			wantErr: "cursor",
+		},
+		{
+			name:    "with a page",
+			qs:      url.Values{"cursor": {cursor}, "page": {"2"}},
+			query:   query,
+			wantErr: "page",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			got := app.readCursor(tt.qs, tt.query, v)
+
+			if tt.wantErr != "" {
+				if _, ok := v.Errors[tt.wantErr]; !ok {
+					t.Errorf("errors = %v, want one for %q", v.Errors, tt.wantErr)
+				}
+				return
+			}
+
+			if !v.Valid() {
+				t.Fatalf("errors = %v", v.Errors)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readCursor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ func (app *application) logError(r *http.Request, err error) {
 	app.logger.PrintError(err, map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
+		"request_id":     app.contextGetRequestID(r),
 	})
 }
 
@@ -110,3 +111,70 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+// insufficientStockResponse sends a JSON-formatted error with a 409 Conflict status code when
+// the store doesn't have enough items left to fulfil the request.
+func (app *application) insufficientStockResponse(w http.ResponseWriter, r *http.Request) {
+	message := "not enough items in stock to fulfil the request"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// invalidTransitionResponse sends a JSON-formatted error message with a 409 Conflict status code
+// when the requested change is valid on its own, but not allowed from the current state of the
+// record. The errors parameter has the same shape as in failedValidationResponse.
+func (app *application) invalidTransitionResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
+	app.errorResponse(w, r, http.StatusConflict, errors)
+}
+
+// categoryHasChildrenResponse sends a JSON-formatted error message with a 409 Conflict status code
+// when a category that still has subcategories is deleted.
+func (app *application) categoryHasChildrenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the category has subcategories, move or delete them first"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// patchTestFailedResponse sends a JSON-formatted error message with a 409 Conflict status code
+// when a "test" operation of a JSON Patch doesn't match the current record.
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusConflict, err.Error())
+}
+
+// unprocessablePatchResponse sends a JSON-formatted error message with a 422 Unprocessable Entity
+// status code when a well-formed patch can't be applied to the record, or doesn't result in one.
+func (app *application) unprocessablePatchResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+}
+
+// payloadTooLargeResponse sends a JSON-formatted error message with a 413 Request Entity Too Large
+// status code when an uploaded file is bigger than allowed.
+func (app *application) payloadTooLargeResponse(w http.ResponseWriter, r *http.Request, maxBytes int64) {
+	message := fmt.Sprintf("the file must not be larger than %d bytes", maxBytes)
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+}
+
+// unsupportedMediaTypeResponse sends a JSON-formatted error message with a 415 Unsupported Media
+// Type status code when an uploaded file is not of one of the accepted types.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// notAcceptableResponse sends a JSON-formatted error message with a 406 Not Acceptable status code
+// when the Accept header doesn't accept any of the formats the response can be sent in.
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the Accept header must accept text/csv, application/x-ndjson or application/json"
+	app.errorResponse(w, r, http.StatusNotAcceptable, message)
+}
+
+// preconditionFailedResponse sends a JSON-formatted error message with a 412 Precondition Failed
+// status code when the If-Match header doesn't match the current version of the record.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has changed since it was read, please fetch it again and retry"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// preconditionRequiredResponse sends a JSON-formatted error message with a 428 Precondition
+// Required status code when a change is sent without the If-Match header the server requires.
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the If-Match header must be set to the ETag of the record"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
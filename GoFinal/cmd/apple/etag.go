@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag returns the strong entity tag of a record at the given version.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// checkIfMatch checks the If-Match header of a request that changes or deletes a record against
+// the current version of the record. It sends the error response and returns false if the header
+// doesn't match, or if it's missing and the server requires it. Only strong tags match, so a
+// weak W/ tag never does.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, version int) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if app.config.requireIfMatch {
+			app.preconditionRequiredResponse(w, r)
+			return false
+		}
+		return true
+	}
+
+	current := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		// The record exists, which is all a * asks for.
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+
+	app.preconditionFailedResponse(w, r)
+	return false
+}
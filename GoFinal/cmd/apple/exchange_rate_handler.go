@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+func (app *application) getExchangeRatesList(w http.ResponseWriter, r *http.Request) {
+	rates, err := app.models.ExchangeRates.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"base": model.BaseCurrency, "exchange_rates": rates}, nil)
+}
+
+// putExchangeRateHandler sets the number of units of a currency one unit of the base currency
+// buys. The rate may be sent as a JSON number or a string, e.g. {"rate": 0.92} or {"rate": "0.92"}.
+func (app *application) putExchangeRateHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Rate json.Number `json:"rate"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	rate := &model.ExchangeRate{
+		Currency: strings.ToUpper(mux.Vars(r)["currency"]),
+		Rate:     input.Rate.String(),
+	}
+
+	v := validator.New()
+
+	if model.ValidateExchangeRate(v, rate); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ExchangeRates.Upsert(rate)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"exchange_rate": rate}, nil)
+}
+
+func (app *application) deleteExchangeRateHandler(w http.ResponseWriter, r *http.Request) {
+	currency := strings.ToUpper(mux.Vars(r)["currency"])
+
+	// The base currency rate is fixed, every other rate is quoted against it.
+	if currency == model.BaseCurrency {
+		app.failedValidationResponse(w, r, map[string]string{"currency": "must not be the base currency " + model.BaseCurrency})
+		return
+	}
+
+	err := app.models.ExchangeRates.Delete(currency)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+const (
+	// exportDeadline replaces the server write timeout for exports, which stream the whole
+	// catalog and take longer than the other requests.
+	exportDeadline = 10 * time.Minute
+
+	// exportFlushEvery is the number of records after which the response is flushed to the client.
+	exportFlushEvery = 500
+)
+
+// exportFormats maps the export formats to their content types.
+var exportFormats = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+	"json":   "application/json",
+}
+
+// acceptFormats maps the media types of an Accept header to the export formats they pick.
+var acceptFormats = map[string]string{
+	"text/csv":             "csv",
+	"application/x-ndjson": "ndjson",
+	"application/ndjson":   "ndjson",
+	"application/jsonl":    "ndjson",
+	"application/json":     "json",
+	"text/*":               "csv",
+	"application/*":        "json",
+	"*/*":                  "json",
+}
+
+// productExportColumns are the columns of a product CSV export. The countries are separated by
+// spaces and the price is in minor units, the same as in an import file, so an export can be
+// imported back.
+var productExportColumns = []string{"id", "createdAt", "updatedAt", "title", "description", "countries", "price", "currency", "categoryId"}
+
+// storeExportColumns are the columns of a store CSV export.
+var storeExportColumns = []string{"id", "createdAt", "updatedAt", "title", "description", "address", "latitude", "longitude", "numberOfBranches", "timeZone"}
+
+// exportProductsHandler streams every product matching the filters of the product list, in a
+// single snapshot of the catalog. The format is picked by the format query parameter or else by
+// the Accept header.
+func (app *application) exportProductsHandler(w http.ResponseWriter, r *http.Request) {
+	var filters model.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	q := app.readProductQuery(qs, v)
+
+	filters.Sort = app.readStrings(qs, "sort", defaultSort(q.Query))
+	filters.SortSafeList = productSortSafeList
+
+	model.ValidateSearch(v, q.Query, filters)
+	model.ValidatePriceSort(v, q, filters)
+	v.Check(validator.In(filters.Sort, filters.SortSafeList...), "sort", "invalid sort value")
+
+	format, ok := app.readExportFormat(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if !ok {
+		app.notAcceptableResponse(w, r)
+		return
+	}
+
+	exp, err := app.newExporter(w, format, "products", productExportColumns)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Products.Export(r.Context(), q, filters, func(product *model.Products) error {
+		var categoryID string
+		if product.CategoryID != nil {
+			categoryID = strconv.FormatInt(*product.CategoryID, 10)
+		}
+
+		return exp.write(product, []string{
+			product.Id, product.CreatedAt, product.UpdatedAt, product.Title, product.Description,
+			strings.Join(product.Countries, " "), strconv.FormatInt(product.Price.Amount, 10), product.Price.Currency, categoryID,
+		})
+	})
+
+	app.finishExport(w, r, exp, err)
+}
+
+// exportStoresHandler streams every store matching the filters of the store list, the same way
+// exportProductsHandler does.
+func (app *application) exportStoresHandler(w http.ResponseWriter, r *http.Request) {
+	var filters model.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	q := app.readStoreQuery(qs, v)
+
+	filters.Sort = app.readStrings(qs, "sort", defaultSort(q.Query))
+	filters.SortSafeList = storeSortSafeList
+
+	model.ValidateSearch(v, q.Query, filters)
+	v.Check(validator.In(filters.Sort, filters.SortSafeList...), "sort", "invalid sort value")
+
+	format, ok := app.readExportFormat(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if !ok {
+		app.notAcceptableResponse(w, r)
+		return
+	}
+
+	exp, err := app.newExporter(w, format, "stores", storeExportColumns)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Stores.Export(r.Context(), q, filters, func(store *model.Store) error {
+		return exp.write(store, []string{
+			store.Id, store.CreatedAt, store.UpdatedAt, store.Title, store.Description, store.Address,
+			formatOptionalFloat(store.Latitude), formatOptionalFloat(store.Longitude),
+			strconv.FormatUint(uint64(store.NumberOfBranches), 10), store.TimeZone,
+		})
+	})
+
+	app.finishExport(w, r, exp, err)
+}
+
+// readExportFormat returns the export format asked for by the format query parameter or else by
+// the Accept header, JSON if neither is given. It reports false when the Accept header doesn't
+// accept any of the formats.
+func (app *application) readExportFormat(r *http.Request, v *validator.Validator) (string, bool) {
+	if format := app.readStrings(r.URL.Query(), "format", ""); format != "" {
+		v.Check(exportFormats[format] != "", "format", "must be csv, ndjson or json")
+		return format, true
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return "json", true
+	}
+
+	type candidate struct {
+		format string
+		q      float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		format, ok := acceptFormats[mediaType]
+		if !ok {
+			continue
+		}
+
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		// Exact media types win over wildcards with the same quality.
+		if strings.HasSuffix(mediaType, "/*") {
+			q -= 0.0001
+		}
+
+		candidates = append(candidates, candidate{format, q})
+	}
+
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+
+	return candidates[0].format, true
+}
+
+// exporter writes the records of an export to the response in one of the export formats. Nothing
+// is sent until the first record is written, so an export that fails before then can still get
+// an error response.
+type exporter struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	bw      *bufio.Writer
+	cw      *csv.Writer
+	format  string
+	name    string
+	columns []string
+	count   int
+}
+
+// newExporter returns the exporter of a response, which gets until exportDeadline to be sent.
+func (app *application) newExporter(w http.ResponseWriter, format, name string, columns []string) (*exporter, error) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(exportDeadline)); err != nil {
+		return nil, err
+	}
+
+	return &exporter{
+		w:       w,
+		rc:      rc,
+		format:  format,
+		name:    name,
+		columns: columns,
+	}, nil
+}
+
+// begin sends the headers and whatever comes before the first record.
+func (e *exporter) begin() error {
+	e.w.Header().Set("Content-Type", exportFormats[e.format])
+	e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, e.name, e.format))
+	e.w.WriteHeader(http.StatusOK)
+
+	e.bw = bufio.NewWriter(e.w)
+
+	switch e.format {
+	case "csv":
+		e.cw = csv.NewWriter(e.bw)
+		return e.cw.Write(e.columns)
+	case "json":
+		_, err := fmt.Fprintf(e.bw, "{%q:[", e.name)
+		return err
+	}
+
+	return nil
+}
+
+// write adds a record to the export. CSV exports write the fields, the others the record as JSON.
+func (e *exporter) write(record interface{}, fields []string) error {
+	if e.bw == nil {
+		if err := e.begin(); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	switch e.format {
+	case "csv":
+		err = e.cw.Write(fields)
+	case "ndjson":
+		err = e.writeJSON(record, "\n")
+	case "json":
+		separator := ","
+		if e.count == 0 {
+			separator = ""
+		}
+		if _, err = e.bw.WriteString(separator); err == nil {
+			err = e.writeJSON(record, "")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	e.count++
+	if e.count%exportFlushEvery == 0 {
+		return e.flush()
+	}
+
+	return nil
+}
+
+func (e *exporter) writeJSON(record interface{}, suffix string) error {
+	js, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err = e.bw.Write(js); err != nil {
+		return err
+	}
+	_, err = e.bw.WriteString(suffix)
+	return err
+}
+
+// end writes whatever comes after the last record and sends the rest of the export.
+func (e *exporter) end() error {
+	if e.bw == nil {
+		if err := e.begin(); err != nil {
+			return err
+		}
+	}
+
+	if e.format == "json" {
+		if _, err := e.bw.WriteString("]}\n"); err != nil {
+			return err
+		}
+	}
+
+	return e.flush()
+}
+
+func (e *exporter) flush() error {
+	if e.cw != nil {
+		e.cw.Flush()
+		if err := e.cw.Error(); err != nil {
+			return err
+		}
+	}
+
+	if err := e.bw.Flush(); err != nil {
+		return err
+	}
+
+	return e.rc.Flush()
+}
+
+// finishExport ends the export, or handles the error that stopped it. An export that has already
+// started can't get an error response anymore, so the connection is aborted instead, which tells
+// the client that the export is incomplete.
+func (app *application) finishExport(w http.ResponseWriter, r *http.Request, exp *exporter, err error) {
+	if err == nil {
+		err = exp.end()
+		if err == nil {
+			return
+		}
+	}
+
+	if exp.bw == nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The client going away is not worth logging.
+	if r.Context().Err() == nil {
+		app.logger.PrintError(err, map[string]string{
+			"request_method": r.Method,
+			"request_url":    r.URL.String(),
+			"exported":       strconv.Itoa(exp.count),
+		})
+	}
+
+	panic(http.ErrAbortHandler)
+}
+
+// formatOptionalFloat formats f for a CSV field, an empty string if it's nil.
+func formatOptionalFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// productFieldSafeList holds the fields the product list can be limited to with "fields".
+var productFieldSafeList = []string{
+	"id", "createdAt", "updatedAt", "title", "description", "countries", "price", "categoryId",
+	"convertedPrice", "highlight",
+}
+
+// productGetFieldSafeList holds the fields a single product can be limited to, which also has
+// its variants and images.
+var productGetFieldSafeList = append(productFieldSafeList[:len(productFieldSafeList):len(productFieldSafeList)], "variants", "images")
+
+// storeFieldSafeList holds the fields the store list and a single store can be limited to.
+var storeFieldSafeList = []string{
+	"id", "createdAt", "updatedAt", "title", "description", "address", "latitude", "longitude",
+	"numberOfBranches", "timeZone", "openNow", "nextOpening", "highlight",
+}
+
+// readInclude reads the comma-separated "include" query string value, which names the related
+// records to embed into each record. Only the given relations can be included.
+func (app *application) readInclude(qs url.Values, v *validator.Validator, relations ...string) []string {
+	include := app.readCSV(qs, "include", nil)
+
+	for _, relation := range include {
+		v.Check(validator.In(relation, relations...), "include", "invalid include value: "+relation)
+	}
+	v.Check(validator.Unique(include), "include", "must not contain duplicate values")
+
+	return include
+}
+
+// wantsField tells whether a field is part of the response, which every field is when the client
+// didn't limit them. It saves working out the fields that would be dropped anyway.
+func wantsField(fields []string, field string) bool {
+	return len(fields) == 0 || validator.In(field, fields...)
+}
+
+// pickFields returns a record, or a slice of them, with only the given fields and the included
+// relations left in their JSON objects, in the order the record has them. The value is returned
+// as it is when no fields are given.
+func pickFields(value interface{}, fields, include []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return value, nil
+	}
+
+	keep := append(fields[:len(fields):len(fields)], include...)
+
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(js, []byte("[")) {
+		if bytes.Equal(js, []byte("null")) {
+			return value, nil
+		}
+		return pickObjectFields(js, keep)
+	}
+
+	var records []json.RawMessage
+	if err := json.Unmarshal(js, &records); err != nil {
+		return nil, err
+	}
+
+	for i := range records {
+		if records[i], err = pickObjectFields(records[i], keep); err != nil {
+			return nil, err
+		}
+	}
+
+	return records, nil
+}
+
+// pickObjectFields returns the JSON object with only the given keys.
+func pickObjectFields(js []byte, keys []string) (json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+
+	// The opening brace.
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+
+		key, _ := token.(string)
+		if !validator.In(key, keys...) {
+			continue
+		}
+
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		// A key that came out of a JSON object always marshals.
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// embedStores adds the stores that carry them to the products, reading them with one query.
+func (app *application) embedStores(products ...*model.Products) error {
+	ids := make([]string, len(products))
+	for i, product := range products {
+		ids[i] = product.Id
+	}
+
+	stores, err := app.models.StoreProducts.GetAllForProducts(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, product := range products {
+		product.Stores = stores[product.Id]
+	}
+
+	return nil
+}
+
+// embedProducts adds their assortment to the stores, reading it with one query.
+func (app *application) embedProducts(stores ...*model.Store) error {
+	ids := make([]string, len(stores))
+	for i, store := range stores {
+		ids[i] = store.Id
+	}
+
+	products, err := app.models.StoreProducts.GetAllForStores(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, store := range stores {
+		store.Products = products[store.Id]
+	}
+
+	return nil
+}
@@ -4,6 +4,9 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/kim0111/GoMidterm/pkg/apple/model"
 	"github.com/kim0111/GoMidterm/pkg/apple/validator"
@@ -11,10 +14,11 @@ import (
 
 func (app *application) createProductsHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title          string `json:"title"`
-		Description    string `json:"description"`
-		ForWhatCountry string `json:"forWhatCountry"`
-		Price          uint   `json:"price"`
+		Title       string      `json:"title"`
+		Description string      `json:"description"`
+		Countries   []string    `json:"countries"`
+		Price       model.Money `json:"price"`
+		CategoryID  *int64      `json:"categoryId"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -25,37 +29,50 @@ func (app *application) createProductsHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	product := &model.Products{
-		Title:          input.Title,
-		Description:    input.Description,
-		ForWhatCountry: input.ForWhatCountry,
-		Price:          input.Price,
+		Title:       input.Title,
+		Description: input.Description,
+		Countries:   model.NormalizeCountries(input.Countries),
+		Price:       input.Price,
+		CategoryID:  input.CategoryID,
 	}
 
-	err = app.models.Products.Insert(product)
+	v := validator.New()
+
+	if err := app.checkCategory(v, product.CategoryID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if model.ValidateProduct(v, product); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Products.Insert(product, app.auditEntry(r, model.AuditCreate, model.AuditProduct, nil))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusCreated, envelope{"products": product}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", etag(product.Version))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"products": product}, headers)
 }
 
 func (app *application) getProductsList(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title     string
-		PriceFrom int
-		PriceTo   int
+		model.ProductQuery
 		model.Filters
 	}
 	v := validator.New()
 	qs := r.URL.Query()
 
-	// Use our helpers to extract the title and nutrition value range query string values, falling back to the
-	// defaults of an empty string and an empty slice, respectively, if they are not provided
-	// by the client.
-	input.Title = app.readStrings(qs, "title", "")
-	input.PriceFrom = app.readInt(qs, "priceFrom", 0, v)
-	input.PriceTo = app.readInt(qs, "priceTo", 0, v)
+	input.ProductQuery = app.readProductQuery(qs, v)
+	currency := app.readCurrency(qs, v)
+
+	// The facets to count the matching products by, e.g. "country,price".
+	facetNames := app.readCSV(qs, "facets", nil)
 
 	// Ge the page and page_size query string value as integers. Notice that we set the default
 	// page value to 1 and default page_size to 20, and that we pass the validator instance
@@ -63,30 +80,101 @@ func (app *application) getProductsList(w http.ResponseWriter, r *http.Request)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
+	// How the total number of products is found. An exact count reads every matching product on every
+	// page, which "estimated" or "none" avoid for large listings.
+	input.Filters.Count = app.readStrings(qs, "count", model.CountExact)
+
 	// Extract the sort query string value, falling back to "id" if it is not provided
-	// by the client (which will imply an ascending sort on product ID).
-	input.Filters.Sort = app.readStrings(qs, "sort", "id")
+	// by the client (which will imply an ascending sort on product ID). Search results are
+	// sorted by relevance by default.
+	input.Filters.Sort = app.readStrings(qs, "sort", defaultSort(input.Query))
 
 	// Add the supported sort value for this endpoint to the sort safelist.
-	// name of the column in the database.
-	input.Filters.SortSafeList = []string{
-		// ascending sort values
-		"id", "title", "price",
-		// descending sort values
-		"-id", "-title", "-price",
-	}
-
+	input.Filters.SortSafeList = productSortSafeList
+
+	// The fields to limit the products to, e.g. "id,title,price", and the related records to
+	// embed into them.
+	input.Filters.Fields = app.readCSV(qs, "fields", nil)
+	input.Filters.FieldSafeList = productFieldSafeList
+	include := app.readInclude(qs, v, "stores")
+
+	// A cursor pages through the products by position, which stays fast and stable however deep
+	// the client pages.
+	fingerprint := cursorQuery(qs, input.Filters.Sort)
+	input.Filters.Cursor = app.readCursor(qs, fingerprint, v)
+
+	model.ValidateSearch(v, input.Query, input.Filters)
+	model.ValidatePriceSort(v, input.ProductQuery, input.Filters)
+	model.ValidateFacets(v, facetNames, model.ProductFacets())
 	if model.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	products, metadata, err := app.models.Products.GetAll(input.Title, input.PriceFrom, input.PriceTo, input.Filters)
+	products, metadata, err := app.models.Products.GetAll(input.ProductQuery, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.setCursors(&metadata, fingerprint)
+
+	if validator.In("stores", include...) {
+		if err := app.embedStores(products...); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if wantsField(input.Filters.Fields, "convertedPrice") && !app.convertPrices(w, r, currency, products...) {
+		return
+	}
+
+	data, err := pickFields(products, input.Filters.Fields, include)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, envelope{"products": products, "metadata": metadata}, nil)
+	env := envelope{"products": data, "metadata": metadata}
+
+	// Facets are counted over every matching product, not just the current page.
+	if len(facetNames) > 0 {
+		facets, err := app.models.Products.Facets(input.ProductQuery, facetNames)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env["facets"] = facets
+	}
+
+	app.writeJSON(w, http.StatusOK, env, nil)
+}
+
+// productSortSafeList holds the sort values of the product list and export.
+var productSortSafeList = []string{
+	// ascending sort values
+	"id", "title", "price", model.SortRelevance,
+	// descending sort values
+	"-id", "-title", "-price",
+}
+
+// readProductQuery reads and validates the product filters shared by the product list and export.
+func (app *application) readProductQuery(qs url.Values, v *validator.Validator) model.ProductQuery {
+	// Use our helpers to extract the title and nutrition value range query string values, falling back to the
+	// defaults of an empty string and an empty slice, respectively, if they are not provided
+	// by the client.
+	var q model.ProductQuery
+	q.Title = app.readStrings(qs, "title", "")
+	q.PriceFrom = app.readInt(qs, "priceFrom", 0, v)
+	q.PriceTo = app.readInt(qs, "priceTo", 0, v)
+	q.PriceCurrency = strings.ToUpper(app.readStrings(qs, "priceCurrency", ""))
+	q.Country = strings.ToUpper(app.readStrings(qs, "country", ""))
+	q.Query = strings.TrimSpace(app.readStrings(qs, "q", ""))
+	q.Category = int64(app.readInt(qs, "category", 0, v))
+
+	model.ValidateProductQuery(v, q)
+
+	return q
 }
 
 func (app *application) getProductHandler(w http.ResponseWriter, r *http.Request) {
@@ -96,7 +184,21 @@ func (app *application) getProductHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	product, err := app.models.Products.Get(id)
+	// An optional "at" query string value asks for the price that was in effect at that moment.
+	v := validator.New()
+	qs := r.URL.Query()
+	at := app.readTime(qs, "at", time.Time{}, v)
+	currency := app.readCurrency(qs, v)
+
+	fields := app.readCSV(qs, "fields", nil)
+	include := app.readInclude(qs, v, "stores")
+
+	if model.ValidateFields(v, fields, productGetFieldSafeList); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	product, err := app.models.Products.GetFields(id, fields)
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrRecordNotFound):
@@ -107,9 +209,119 @@ func (app *application) getProductHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, envelope{"products": product}, nil)
+	if !at.IsZero() {
+		product.Price, err = app.models.Products.PriceAt(id, at)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrRecordNotFound):
+				v.AddError("at", "must not be before the product was created")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	if wantsField(fields, "variants") {
+		product.Variants, err = app.models.Variants.GetAllForProduct(int64(id))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if wantsField(fields, "images") {
+		product.Images, err = app.models.Images.GetAllForProduct(int64(id))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.setImageURLs(product.Images...)
+	}
+
+	if validator.In("stores", include...) {
+		if err := app.embedStores(product); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if wantsField(fields, "convertedPrice") && !app.convertPrices(w, r, currency, product) {
+		return
+	}
+
+	data, err := pickFields(product, fields, include)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(product.Version))
+
+	app.writeJSON(w, http.StatusOK, envelope{"products": data}, headers)
 }
 
+// getProductPricesHandler returns the price history of a product.
+func (app *application) getProductPricesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Products.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	prices, err := app.models.Products.GetPrices(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"prices": prices}, nil)
+}
+
+// productInput is the part of a product a client writes: a PUT replaces it as a whole and a
+// PATCH is applied to it.
+type productInput struct {
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Countries   []string    `json:"countries"`
+	Price       model.Money `json:"price"`
+	CategoryID  *int64      `json:"categoryId"`
+}
+
+func newProductInput(product *model.Products) productInput {
+	return productInput{
+		Title:       product.Title,
+		Description: product.Description,
+		Countries:   product.Countries,
+		Price:       product.Price,
+		CategoryID:  product.CategoryID,
+	}
+}
+
+// applyTo replaces the written fields of the product with the input.
+func (input productInput) applyTo(product *model.Products) {
+	product.Title = input.Title
+	product.Description = input.Description
+	product.Countries = model.NormalizeCountries(input.Countries)
+	product.Price = input.Price
+	product.CategoryID = input.CategoryID
+}
+
+// updateProductHandler replaces a product. Fields left out of the body are cleared, so the body
+// must hold the whole product; PATCH changes only some of its fields.
 func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -128,45 +340,79 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	var input struct {
-		Title          *string `json:"title"`
-		Description    *string `json:"description"`
-		ForWhatCountry *string `json:"forWhatCountry"`
-		Price          *uint   `json:"price"`
+	if !app.checkIfMatch(w, r, product.Version) {
+		return
 	}
 
+	before := *product
+
+	var input productInput
 	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	if input.Title != nil {
-		product.Title = *input.Title
+	input.applyTo(product)
+
+	app.saveProduct(w, r, product, &before)
+}
+
+// patchProductHandler changes some fields of a product with a JSON Merge Patch or a JSON Patch.
+func (app *application) patchProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
 	}
 
-	if &input.Description != nil {
-		product.Description = *input.Description
+	product, err := app.models.Products.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
-	if &input.ForWhatCountry != nil {
-		product.ForWhatCountry = *input.ForWhatCountry
+	if !app.checkIfMatch(w, r, product.Version) {
+		return
 	}
 
-	if &input.Price != nil {
-		product.Price = *input.Price
+	before := *product
+
+	var input productInput
+	if !app.applyPatch(w, r, newProductInput(product), &input) {
+		return
 	}
 
+	input.applyTo(product)
+
+	app.saveProduct(w, r, product, &before)
+}
+
+// saveProduct validates and saves a changed product and sends it back. before is the product as
+// it was loaded, for the audit log.
+func (app *application) saveProduct(w http.ResponseWriter, r *http.Request, product, before *model.Products) {
 	v := validator.New()
 
+	if err := app.checkCategory(v, product.CategoryID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	if model.ValidateProduct(v, product); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Products.Update(product)
+	err := app.models.Products.Update(product, app.auditEntry(r, model.AuditUpdate, model.AuditProduct, before))
 	if err != nil {
 		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		case errors.Is(err, model.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
@@ -175,7 +421,10 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, envelope{"products": product}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", etag(product.Version))
+
+	app.writeJSON(w, http.StatusOK, envelope{"products": product}, headers)
 }
 
 func (app *application) deleteProductHandler(w http.ResponseWriter, r *http.Request) {
@@ -185,7 +434,8 @@ func (app *application) deleteProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.models.Products.Delete(id)
+	// Read the product first, so the audit log can tell what was deleted.
+	product, err := app.models.Products.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrRecordNotFound):
@@ -196,5 +446,66 @@ func (app *application) deleteProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if !app.checkIfMatch(w, r, product.Version) {
+		return
+	}
+
+	// The product is only moved to the trash, its image files are removed when it's purged.
+	err = app.models.Products.Delete(id, product.Version, app.auditEntry(r, model.AuditDelete, model.AuditProduct, product))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
 }
+
+// readCurrency reads the optional "currency" query string value the client wants the prices
+// converted into. An unsupported currency is recorded in the provided Validator instance.
+func (app *application) readCurrency(qs url.Values, v *validator.Validator) string {
+	currency := strings.ToUpper(app.readStrings(qs, "currency", ""))
+	if currency != "" {
+		v.Check(model.IsCurrency(currency), "currency", "must be a supported ISO 4217 currency")
+	}
+
+	return currency
+}
+
+// convertPrices fills the converted price of the products in the given currency. If currency is
+// empty, it does nothing. If the conversion fails it sends the error response itself and returns
+// false, so the handler should just return.
+func (app *application) convertPrices(w http.ResponseWriter, r *http.Request, currency string, products ...*model.Products) bool {
+	if currency == "" {
+		return true
+	}
+
+	rates, err := app.models.ExchangeRates.Rates()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	for _, product := range products {
+		converted, err := product.Price.Convert(currency, rates)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrNoExchangeRate):
+				app.failedValidationResponse(w, r, map[string]string{"currency": err.Error()})
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return false
+		}
+
+		product.ConvertedPrice = &converted
+	}
+
+	return true
+}
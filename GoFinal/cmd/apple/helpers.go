@@ -5,12 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
 	"github.com/kim0111/GoMidterm/pkg/apple/validator"
 )
 
@@ -20,12 +23,18 @@ type envelope map[string]interface{}
 // readIDParam reads interpolated "id" from request URL and returns it and nil. If there is an error
 // it returns and 0 and an error.
 func (app *application) readIDParam(r *http.Request) (int, error) {
+	return app.readIntParam(r, "id")
+}
+
+// readIntParam reads the interpolated parameter with the given name from request URL, the same
+// way as readIDParam does for "id".
+func (app *application) readIntParam(r *http.Request, name string) (int, error) {
 	vars := mux.Vars(r)
-	param := vars["id"]
+	param := vars[name]
 
 	id, err := strconv.Atoi(param)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -65,19 +74,26 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+// maxJSONBytes is the largest JSON request body.
+const maxJSONBytes = 1_048_576
+
 // readJSON decodes request Body into corresponding Go type. It triages for any potential errors
 // and returns corresponding appropriate errors.
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	// Use http.MaxBytesReader() to limit the size of the request body to 1MB to prevent
 	// any potential nefarious DoS attacks.
-	maxBytes := 1_048_576
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBytes)
 
+	return decodeJSON(r.Body, dst)
+}
+
+// decodeJSON decodes a single JSON value into dst, with the same error triage as readJSON.
+func decodeJSON(body io.Reader, dst interface{}) error {
 	// Initialize the json.Decoder, and call the DisallowUnknownFields() method on it
 	// before decoding. So, if the JSON from the client includes any field which
 	// cannot be mapped to the target destination, the decoder will return an error
 	// instead of just ignoring the field.
-	dec := json.NewDecoder(r.Body)
+	dec := json.NewDecoder(body)
 	dec.DisallowUnknownFields()
 
 	// Decode the request body to the destination.
@@ -133,7 +149,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 		// error "http: request body too large". There is an open issue about turning
 		// this into a distinct error type at https://github.com/golang/go/issues/30715.
 		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+			return fmt.Errorf("body must not be larger than %d bytes", maxJSONBytes)
 
 		// A json.InvalidUnmarshalError error will be returned if we pass a non-nil
 		// pointer to Decode(). We catch this and panic, rather than returning an error
@@ -177,6 +193,18 @@ func (app *application) readStrings(qs url.Values, key string, defaultValue stri
 	return s
 }
 
+// readCSV reads a comma-separated list from the URL query string, such as "country,price". If no
+// matching key is found then it returns the provided default value.
+func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+	csv := qs.Get(key)
+
+	if csv == "" {
+		return defaultValue
+	}
+
+	return strings.Split(csv, ",")
+}
+
 // readInt is a helper method on application type that reads a string value from the URL query
 // string and converts it to an integer before returning. If no matching key is found then it
 // returns the provided default value. If the value couldn't be converted to an integer, then we
@@ -201,3 +229,70 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// Otherwise, return the converted integer value.
 	return i
 }
+
+// readFloat reads a float64 value from the URL query string. If no matching key is found then it
+// returns the provided default value. If the value couldn't be converted, then we record an error
+// message in the provided Validator instance, and return the default value.
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+
+	return f
+}
+
+// readBool reads an optional boolean value from the URL query string. It returns nil if no matching
+// key is found, or if the value couldn't be converted, in which case we also record an error
+// message in the provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return nil
+	}
+
+	return &b
+}
+
+// readTime reads an RFC 3339 timestamp from the URL query string. If no matching key is found
+// then it returns the provided default value. If the value couldn't be parsed, then we record an
+// error message in the provided Validator instance, and return the default value.
+func (app *application) readTime(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return defaultValue
+	}
+
+	return t
+}
+
+// defaultSort returns the sort value of a listing when the client doesn't provide one: search
+// results are sorted by relevance, everything else by ID.
+func defaultSort(query string) string {
+	if query != "" {
+		return model.SortRelevance
+	}
+
+	return "id"
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+	"github.com/kim0111/GoMidterm/pkg/storage"
+	"github.com/kim0111/GoMidterm/pkg/thumbnail"
+)
+
+const (
+	// thumbnailSize is the size of the square the thumbnails are scaled into.
+	thumbnailSize = 320
+
+	// maxImageDimension limits the width and height of uploaded images, so a small, highly
+	// compressed file can't make the server decode a gigantic picture.
+	maxImageDimension = 5000
+)
+
+// imageTypes maps the accepted image content types, as sniffed from the file content, to their
+// file extensions.
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// uploadProductImageHandler accepts a multipart/form-data upload with the image file in the
+// "image" field and an optional "primary" field set to "true". It stores the image together with
+// a thumbnail and adds it as the last image of the product.
+func (app *application) uploadProductImageHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Products.Get(productID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data, primary, err := app.readImageUpload(w, r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.Is(err, errImageTooLarge), errors.As(err, &tooLarge):
+			app.payloadTooLargeResponse(w, r, app.config.images.maxBytes)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	ext, ok := imageTypes[contentType]
+	if !ok {
+		app.unsupportedMediaTypeResponse(w, r, "the image must be a JPEG, PNG or GIF file")
+		return
+	}
+
+	v := validator.New()
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		v.AddError("image", "must be a valid image file")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	v.Check(cfg.Width <= maxImageDimension && cfg.Height <= maxImageDimension, "image",
+		fmt.Sprintf("must not be larger than %dx%d pixels", maxImageDimension, maxImageDimension))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		v.AddError("image", "must be a valid image file")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	thumb, err := encodeThumbnail(img, contentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Stored files are never changed, a new upload always gets new keys. That's what makes
+	// serving them with long-lived cache headers safe.
+	name, err := randomName()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	productImage := &model.ProductImage{
+		ProductID:    int64(productID),
+		ContentType:  contentType,
+		Size:         int64(len(data)),
+		Width:        cfg.Width,
+		Height:       cfg.Height,
+		Checksum:     hex.EncodeToString(sum[:]),
+		StorageKey:   fmt.Sprintf("products/%d/%s%s", productID, name, ext),
+		ThumbnailKey: fmt.Sprintf("products/%d/%s_thumb%s", productID, name, ext),
+		Primary:      primary,
+	}
+
+	err = app.storage.Put(r.Context(), productImage.StorageKey, bytes.NewReader(data))
+	if err == nil {
+		err = app.storage.Put(r.Context(), productImage.ThumbnailKey, bytes.NewReader(thumb))
+	}
+	if err == nil {
+		err = app.models.Images.Insert(productImage)
+	}
+	if err != nil {
+		app.deleteImageFiles(productImage)
+
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.setImageURLs(productImage)
+
+	app.writeJSON(w, http.StatusCreated, envelope{"image": productImage}, nil)
+}
+
+func (app *application) getProductImagesList(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Products.Get(productID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	images, err := app.models.Images.GetAllForProduct(int64(productID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setImageURLs(images...)
+
+	app.writeJSON(w, http.StatusOK, envelope{"images": images}, nil)
+}
+
+// reorderProductImagesHandler puts the images of a product into the given order. The list has to
+// contain every image of the product.
+func (app *application) reorderProductImagesHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ImageIDs []int64 `json:"imageIds"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if model.ValidateImageOrder(v, input.ImageIDs); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Images.Reorder(int64(productID), input.ImageIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("imageIds", "must contain every image of the product exactly once")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	images, err := app.models.Images.GetAllForProduct(int64(productID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setImageURLs(images...)
+
+	app.writeJSON(w, http.StatusOK, envelope{"images": images}, nil)
+}
+
+// setPrimaryProductImageHandler makes an image the primary image of its product.
+func (app *application) setPrimaryProductImageHandler(w http.ResponseWriter, r *http.Request) {
+	productID, imageID, err := app.readImageIDs(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Images.SetPrimary(productID, imageID)
+	if err == nil {
+		var productImage *model.ProductImage
+		productImage, err = app.models.Images.Get(productID, imageID)
+		if err == nil {
+			app.setImageURLs(productImage)
+			app.writeJSON(w, http.StatusOK, envelope{"image": productImage}, nil)
+			return
+		}
+	}
+
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteProductImageHandler(w http.ResponseWriter, r *http.Request) {
+	productID, imageID, err := app.readImageIDs(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	productImage, err := app.models.Images.Delete(productID, imageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deleteImageFiles(productImage)
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+// serveProductImageHandler serves the image file, or its thumbnail on the thumbnail route. Stored
+// files never change, so clients and proxies may cache them for good. http.ServeContent answers
+// conditional (If-None-Match, If-Modified-Since) and range requests.
+func (app *application) serveProductImageHandler(thumbnail bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		productID, imageID, err := app.readImageIDs(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		productImage, err := app.models.Images.Get(productID, imageID)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		key, etag := productImage.StorageKey, `"`+productImage.Checksum+`"`
+		if thumbnail {
+			key, etag = productImage.ThumbnailKey, `"`+productImage.Checksum+`-thumb"`
+		}
+
+		f, err := app.storage.Open(r.Context(), key)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", productImage.ContentType)
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("ETag", etag)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		http.ServeContent(w, r, "", f.ModTime(), f)
+	}
+}
+
+// errImageTooLarge is returned by readImageUpload when the image is bigger than the configured
+// limit.
+var errImageTooLarge = errors.New("image too large")
+
+// readImageUpload reads the "image" file and the "primary" flag from a multipart/form-data
+// request. The parts are streamed, so at most the image limit is ever held in memory.
+func (app *application) readImageUpload(w http.ResponseWriter, r *http.Request) ([]byte, bool, error) {
+	maxBytes := app.config.images.maxBytes
+
+	// Leave some room for the multipart boundaries and the other fields.
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64<<10)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, false, errors.New("body must be multipart/form-data")
+	}
+
+	var data []byte
+	var primary bool
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		switch part.FormName() {
+		case "image":
+			data, err = io.ReadAll(io.LimitReader(part, maxBytes+1))
+			if err != nil {
+				return nil, false, err
+			}
+			if int64(len(data)) > maxBytes {
+				return nil, false, errImageTooLarge
+			}
+		case "primary":
+			value, err := io.ReadAll(io.LimitReader(part, 16))
+			if err != nil {
+				return nil, false, err
+			}
+			primary = string(value) == "true"
+		default:
+			return nil, false, fmt.Errorf("body contains unknown field %q", part.FormName())
+		}
+	}
+
+	if len(data) == 0 {
+		return nil, false, errors.New("body must contain an image file in the \"image\" field")
+	}
+
+	return data, primary, nil
+}
+
+// encodeThumbnail scales the image down and encodes it in the format of the original, so
+// transparency survives. GIFs are encoded as a single frame.
+func encodeThumbnail(img image.Image, contentType string) ([]byte, error) {
+	thumb := thumbnail.Fit(img, thumbnailSize)
+
+	var buf bytes.Buffer
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+	case "image/gif":
+		err = gif.Encode(&buf, thumb, nil)
+	default:
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// randomName returns a random name for a stored file.
+func randomName() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// readImageIDs reads the interpolated "id" of the product and "imageId" from the request URL.
+func (app *application) readImageIDs(r *http.Request) (int64, int64, error) {
+	productID, err := app.readIDParam(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	imageID, err := app.readIntParam(r, "imageId")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int64(productID), int64(imageID), nil
+}
+
+// setImageURLs fills in the URLs the image files are served at.
+func (app *application) setImageURLs(images ...*model.ProductImage) {
+	for _, productImage := range images {
+		base := fmt.Sprintf("/api/v1/products/%d/images/%d", productImage.ProductID, productImage.ID)
+		productImage.URL = base + "/file"
+		productImage.ThumbnailURL = base + "/thumbnail"
+	}
+}
+
+// deleteImageFiles removes the files of the images from the storage. A failure only leaves an
+// orphaned file behind, so it's logged rather than reported to the client.
+func (app *application) deleteImageFiles(images ...*model.ProductImage) {
+	for _, productImage := range images {
+		for _, key := range []string{productImage.StorageKey, productImage.ThumbnailKey} {
+			if err := app.storage.Delete(context.Background(), key); err != nil {
+				app.logger.PrintError(err, map[string]string{"key": key})
+			}
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+const (
+	// maxImportRows is the largest number of rows a single import may have.
+	maxImportRows = 50_000
+
+	// importDeadline replaces the server read and write timeouts for imports, which take longer
+	// to upload and to run than the other requests.
+	importDeadline = 3 * time.Minute
+)
+
+// importColumns are the columns a CSV import file can have, in any order. "countries" is a list
+// separated by spaces, commas or semicolons, "price" is the amount in minor units.
+var importColumns = []string{"id", "title", "description", "countries", "price", "currency", "categoryId"}
+
+// importIgnoredColumns are the columns of a product export that an import file may have but that
+// are not imported, so an export can be imported back as it is.
+var importIgnoredColumns = []string{"createdAt", "updatedAt"}
+
+// importInput is a row of an NDJSON import file, the same fields the products are created with
+// plus an optional ID of the product to update.
+type importInput struct {
+	ID          int64       `json:"id"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Countries   []string    `json:"countries"`
+	Price       model.Money `json:"price"`
+	CategoryID  *int64      `json:"categoryId"`
+}
+
+// importProductsHandler creates and updates products in bulk from a CSV (text/csv) or NDJSON
+// (application/x-ndjson) file. The body is limited by -import-max-bytes instead of like the JSON
+// bodies and to maxImportRows rows. It is streamed into the database: the rows are validated as
+// they are read and written in batches while the rest of the file is still being uploaded. Rows
+// with an ID update that product, the others create new ones. The mode query parameter picks
+// between an all-or-nothing "atomic" import (the default) and a "best_effort" one.
+func (app *application) importProductsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	mode := app.readStrings(qs, "mode", model.ImportAtomic)
+
+	v := validator.New()
+	v.Check(validator.In(mode, model.ImportAtomic, model.ImportBestEffort), "mode", "must be atomic or best_effort")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var open func(io.Reader) (func() (*model.ImportRow, error), error)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		open = newCSVImport
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		open = newNDJSONImport
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "the import file must be text/csv or application/x-ndjson")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Now().Add(importDeadline)); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := rc.SetWriteDeadline(time.Now().Add(importDeadline)); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.imports.maxBytes)
+
+	next, err := open(r.Body)
+	if err != nil {
+		app.importReadErrorResponse(w, r, err)
+		return
+	}
+
+	// The file can turn out to be malformed or too large halfway through the import, which is then
+	// rolled back. readErr tells that apart from the import failing.
+	var readErr error
+	rows := 0
+	validated := func() (*model.ImportRow, error) {
+		row, err := next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				readErr = err
+			}
+			return nil, err
+		}
+
+		if rows++; rows > maxImportRows {
+			readErr = fmt.Errorf("the import file must not contain more than %d rows", maxImportRows)
+			return nil, readErr
+		}
+
+		if row.Errors == nil {
+			row.Product.Countries = model.NormalizeCountries(row.Product.Countries)
+
+			v := validator.New()
+			if model.ValidateProduct(v, row.Product); !v.Valid() {
+				row.Errors = v.Errors
+			}
+		}
+
+		return row, nil
+	}
+
+	report, err := app.models.Products.Import(validated, mode, app.auditEntry(r, model.AuditImport, model.AuditProduct, nil))
+	switch {
+	case readErr != nil:
+		app.importReadErrorResponse(w, r, readErr)
+		return
+	case err != nil && !errors.Is(err, model.ErrImportFailed):
+		app.serverErrorResponse(w, r, err)
+		return
+	case len(report.Rows) == 0:
+		app.badRequestResponse(w, r, errors.New("the import file must contain at least one row"))
+		return
+	}
+
+	status := http.StatusOK
+	if errors.Is(err, model.ErrImportFailed) {
+		status = http.StatusUnprocessableEntity
+	}
+
+	app.writeJSON(w, status, envelope{"import": report}, nil)
+}
+
+// importReadErrorResponse answers an import whose file can't be read: a 413 if it's larger than
+// -import-max-bytes, a 400 otherwise.
+func (app *application) importReadErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		app.payloadTooLargeResponse(w, r, app.config.imports.maxBytes)
+	default:
+		app.badRequestResponse(w, r, err)
+	}
+}
+
+// newCSVImport reads the header of a CSV import file, naming the columns, and returns a function
+// reading the rows one at a time. Values that can't be parsed fail their row only.
+func newCSVImport(body io.Reader) (func() (*model.ImportRow, error), error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return func() (*model.ImportRow, error) { return nil, io.EOF }, nil
+		}
+		return nil, csvImportError(err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if validator.In(name, importIgnoredColumns...) {
+			continue
+		}
+		if !validator.In(name, importColumns...) {
+			return nil, fmt.Errorf("the CSV header contains unknown column %q, the columns are %s", name, strings.Join(importColumns, ", "))
+		}
+		if _, exists := columns[name]; exists {
+			return nil, fmt.Errorf("the CSV header contains column %q twice", name)
+		}
+		columns[name] = i
+	}
+
+	return func() (*model.ImportRow, error) {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, csvImportError(err)
+		}
+
+		return readCSVRow(cr, header, columns, record), nil
+	}, nil
+}
+
+// readCSVRow reads the product of a CSV record.
+func readCSVRow(cr *csv.Reader, header []string, columns map[string]int, record []string) *model.ImportRow {
+	line, _ := cr.FieldPos(0)
+	row := &model.ImportRow{Line: line, Product: &model.Products{}}
+
+	if len(record) != len(header) {
+		row.Errors = map[string]string{"row": fmt.Sprintf("must have %d fields like the header", len(header))}
+		return row
+	}
+
+	value := func(name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	v := validator.New()
+	product := row.Product
+
+	if s := value("id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		v.Check(err == nil && id > 0, "id", "must be a product ID")
+		product.Id = strconv.FormatInt(id, 10)
+	}
+
+	product.Title = value("title")
+	product.Description = value("description")
+	product.Countries = strings.FieldsFunc(value("countries"), func(r rune) bool {
+		return r == ',' || r == ';' || r == ' '
+	})
+
+	if s := value("price"); s != "" {
+		amount, err := strconv.ParseInt(s, 10, 64)
+		v.Check(err == nil, "price", "must be an amount in minor units")
+		product.Price.Amount = amount
+	}
+	product.Price.Currency = strings.ToUpper(value("currency"))
+
+	if s := value("categoryId"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		v.Check(err == nil && id > 0, "categoryId", "must be a category ID")
+		product.CategoryID = &id
+	}
+
+	if !v.Valid() {
+		row.Errors = v.Errors
+	}
+
+	return row
+}
+
+// csvImportError returns the error of a CSV file that can't be read any further.
+func csvImportError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("the CSV file is malformed on line %d: %w", parseErr.Line, parseErr.Err)
+	}
+
+	return err
+}
+
+// newNDJSONImport returns a function reading the rows of an NDJSON import file one at a time, a
+// JSON object per line. Blank lines are skipped, a line that isn't a valid product object fails its
+// row only.
+func newNDJSONImport(body io.Reader) (func() (*model.ImportRow, error), error) {
+	br := bufio.NewReader(body)
+	line := 0
+
+	return func() (*model.ImportRow, error) {
+		for {
+			data, err := br.ReadBytes('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			line++
+
+			if len(bytes.TrimSpace(data)) > 0 {
+				return readNDJSONRow(line, data), nil
+			}
+
+			if errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+		}
+	}, nil
+}
+
+func readNDJSONRow(line int, data []byte) *model.ImportRow {
+	row := &model.ImportRow{Line: line, Product: &model.Products{}}
+
+	var input importInput
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&input); err != nil {
+		row.Errors = map[string]string{"row": "must be a JSON object with the product fields: " + err.Error()}
+		return row
+	}
+
+	if input.ID != 0 {
+		if input.ID < 0 {
+			row.Errors = map[string]string{"id": "must be a product ID"}
+		}
+		row.Product.Id = strconv.FormatInt(input.ID, 10)
+	}
+
+	row.Product.Title = input.Title
+	row.Product.Description = input.Description
+	row.Product.Countries = input.Countries
+	row.Product.Price = input.Price
+	row.Product.CategoryID = input.CategoryID
+
+	return row
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// readInventoryFilters reads the pagination and sort query string values shared by the inventory
+// list endpoints.
+func (app *application) readInventoryFilters(r *http.Request, v *validator.Validator) model.Filters {
+	qs := r.URL.Query()
+
+	var filters model.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readStrings(qs, "sort", "id")
+	filters.SortSafeList = []string{
+		// ascending sort values
+		"id", "quantity",
+		// descending sort values
+		"-id", "-quantity",
+	}
+
+	return filters
+}
+
+func (app *application) getStoreProductsList(w http.ResponseWriter, r *http.Request) {
+	storeID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	filters := app.readInventoryFilters(r, v)
+
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Make sure that the store exists, so an unknown store is a 404 instead of an empty list.
+	_, err = app.models.Stores.Get(storeID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	items, metadata, err := app.models.StoreProducts.GetAllForStore(storeID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"products": items, "metadata": metadata}, nil)
+}
+
+func (app *application) getProductStoresList(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	filters := app.readInventoryFilters(r, v)
+
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Products.Get(productID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	items, metadata, err := app.models.StoreProducts.GetAllForProduct(productID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"stores": items, "metadata": metadata}, nil)
+}
+
+func (app *application) getStoreProductHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	productID, err := app.readIntParam(r, "productId")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	variantID, err := app.readVariantParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	item, err := app.models.StoreProducts.Get(storeID, productID, variantID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"inventory": item}, nil)
+}
+
+func (app *application) putStoreProductHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	productID, err := app.readIntParam(r, "productId")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	variantID, err := app.readVariantParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Quantity *uint `json:"quantity"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Quantity != nil, "quantity", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	item := &model.StoreProduct{
+		StoreId:   strconv.Itoa(storeID),
+		ProductId: strconv.Itoa(productID),
+		Quantity:  *input.Quantity,
+	}
+	if variantID != 0 {
+		item.VariantId = &variantID
+	}
+
+	if model.ValidateStoreProduct(v, item); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Both sides of the relation have to exist, otherwise the foreign keys would turn the
+	// request into a 500.
+	_, err = app.models.Stores.Get(storeID)
+	if err == nil {
+		_, err = app.models.Products.Get(productID)
+	}
+	if err == nil && variantID != 0 {
+		_, err = app.models.Variants.Get(int64(productID), variantID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.StoreProducts.Upsert(item)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"inventory": item}, nil)
+}
+
+func (app *application) deleteStoreProductHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	productID, err := app.readIntParam(r, "productId")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	variantID, err := app.readVariantParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.StoreProducts.Delete(storeID, productID, variantID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+// readVariantParam reads the optional interpolated "variantId" from the request URL. It returns 0
+// on the routes without a variant, which address the stock of the product itself.
+func (app *application) readVariantParam(r *http.Request) (int64, error) {
+	if _, ok := mux.Vars(r)["variantId"]; !ok {
+		return 0, nil
+	}
+
+	id, err := app.readIntParam(r, "variantId")
+	return int64(id), err
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// schedule runs fn in a background goroutine every interval until done is closed. Errors are
+// logged, so a single failed run doesn't stop the job. The goroutine is tracked by app.wg, so
+// the graceful shutdown waits for a run in progress to finish.
+func (app *application) schedule(done <-chan struct{}, name string, interval time.Duration, fn func() error) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		// Recover any panic, so a broken job doesn't take the whole server down with it.
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), map[string]string{"job": name})
+			}
+		}()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := fn(); err != nil {
+					app.logger.PrintError(err, map[string]string{"job": name})
+				}
+			}
+		}
+	}()
+}
+
+// startJobs starts the periodic housekeeping jobs of the application.
+func (app *application) startJobs(done <-chan struct{}) {
+	// Give the stock of expired reservations back to the stores.
+	app.schedule(done, "release_expired_reservations", time.Minute, func() error {
+		_, err := app.models.Reservations.ReleaseExpired()
+		return err
+	})
+
+	// Purge the products and stores that have been in the trash for too long.
+	if app.config.trash.retention > 0 {
+		app.schedule(done, "purge_trash", time.Hour, app.purgeTrash)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// createOrderHandler checks out a set of products from a store for the current user.
+func (app *application) createOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		StoreID *int64 `json:"storeId"`
+		Items   []struct {
+			ProductID *int64 `json:"productId"`
+			VariantID *int64 `json:"variantId"`
+			Quantity  int    `json:"quantity"`
+		} `json:"items"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	order := &model.Order{
+		UserID:  app.contextGetUser(r).ID,
+		StoreID: input.StoreID,
+	}
+	for _, item := range input.Items {
+		order.Lines = append(order.Lines, &model.OrderLine{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Quantity:  item.Quantity,
+		})
+	}
+
+	v := validator.New()
+
+	if model.ValidateOrder(v, order); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Stores.Get(int(*order.StoreID))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("storeId", "must be an existing store")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Orders.Insert(order)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrProductUnavailable):
+			v.AddError("items", "must only contain products available in this store")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrMixedCurrencies):
+			v.AddError("items", "must only contain products priced in the same currency")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrInsufficientStock):
+			app.insufficientStockResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"order": order}, nil)
+}
+
+func (app *application) getOrdersList(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		model.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readStrings(qs, "sort", "-id")
+	input.Filters.SortSafeList = []string{
+		// ascending sort values
+		"id", "created_at", "total",
+		// descending sort values
+		"-id", "-created_at", "-total",
+	}
+
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	orders, metadata, err := app.models.Orders.GetAllForUser(app.contextGetUser(r).ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"orders": orders, "metadata": metadata}, nil)
+}
+
+func (app *application) getOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Users only see their own orders, anything else is reported as not found.
+	order, err := app.models.Orders.GetForUser(int64(id), app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"order": order}, nil)
+}
+
+// updateOrderStatusHandler moves an order through its lifecycle. The allowed changes are defined
+// by the order state machine in the model package.
+func (app *application) updateOrderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if model.ValidateOrderStatus(v, input.Status, input.Note); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Orders.UpdateStatus(int64(id), input.Status, app.contextGetUser(r).ID, input.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrInvalidTransition):
+			v.AddError("status", err.Error())
+			app.invalidTransitionResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	order, err := app.models.Orders.Get(int64(id))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"order": order}, nil)
+}
+
+// getOrderEventsHandler returns the status history of an order of the current user. Users with
+// the orders:write permission, who change the status of the orders, can read that of any order.
+func (app *application) getOrderEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var order *model.Order
+	if permissions.Include("orders:write") {
+		order, err = app.models.Orders.Get(int64(id))
+	} else {
+		order, err = app.models.Orders.GetForUser(int64(id), user.ID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	events, err := app.models.Orders.GetEvents(order.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"events": events}, nil)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/kim0111/GoMidterm/pkg/jsonpatch"
+)
+
+// The media types of the patch documents a PATCH request can send.
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// applyPatch applies the JSON Merge Patch or JSON Patch in the request body, told apart by its
+// Content-Type, to the JSON of current and decodes the result into dst, which must be a zero value.
+// It sends the error response and returns false if the patch can't be applied.
+func (app *application) applyPatch(w http.ResponseWriter, r *http.Request, current, dst interface{}) bool {
+	var apply func(doc, patch []byte) ([]byte, error)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mergePatchType:
+		apply = jsonpatch.MergePatch
+	case jsonPatchType:
+		apply = jsonpatch.Apply
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "the patch must be "+mergePatchType+" or "+jsonPatchType)
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBytes)
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxJSONBytes))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return false
+	}
+
+	doc, err := json.Marshal(current)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	patched, err := apply(doc, patch)
+	if err != nil {
+		switch {
+		case errors.Is(err, jsonpatch.ErrInvalidPatch):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			app.patchTestFailedResponse(w, r, err)
+		case errors.Is(err, jsonpatch.ErrPathNotFound):
+			app.unprocessablePatchResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	// The patch can add fields that can't be written or give them the wrong type, which is
+	// reported like the same mistake in a request body.
+	if err := decodeJSON(bytes.NewReader(patched), dst); err != nil {
+		app.unprocessablePatchResponse(w, r, fmt.Errorf("patched %v", err))
+		return false
+	}
+
+	return true
+}
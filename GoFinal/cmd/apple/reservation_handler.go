@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+func (app *application) createReservationHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ProductID int64  `json:"productId"`
+		VariantID *int64 `json:"variantId"`
+		Quantity  int    `json:"quantity"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	reservation := &model.Reservation{
+		StoreID:   int64(storeID),
+		ProductID: input.ProductID,
+		VariantID: input.VariantID,
+		UserID:    app.contextGetUser(r).ID,
+		Quantity:  input.Quantity,
+		ExpiresAt: time.Now().Add(app.config.reservationTTL),
+	}
+
+	v := validator.New()
+
+	if model.ValidateReservation(v, reservation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reservations.Insert(reservation)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			if reservation.VariantID != nil {
+				v.AddError("variantId", "is not available in this store")
+			} else {
+				v.AddError("productId", "is not available in this store")
+			}
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrInsufficientStock):
+			app.insufficientStockResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"reservation": reservation}, nil)
+}
+
+func (app *application) getReservationHandler(w http.ResponseWriter, r *http.Request) {
+	reservation, err := app.readReservation(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"reservation": reservation}, nil)
+}
+
+// releaseReservationHandler cancels an active reservation and puts the reserved quantity back
+// into the store stock.
+func (app *application) releaseReservationHandler(w http.ResponseWriter, r *http.Request) {
+	reservation, err := app.readReservation(r)
+	if err == nil {
+		err = app.models.Reservations.Release(reservation)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrReservationNotActive):
+			app.errorResponse(w, r, http.StatusConflict, "the reservation has already been released or has expired")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"reservation": reservation}, nil)
+}
+
+// readReservation loads the reservation addressed by the request URL. Users can only see their
+// own reservations, so any other one is reported as not found.
+func (app *application) readReservation(r *http.Request) (*model.Reservation, error) {
+	storeID, err := app.readIDParam(r)
+	if err != nil {
+		return nil, model.ErrRecordNotFound
+	}
+
+	reservationID, err := app.readIntParam(r, "reservationId")
+	if err != nil {
+		return nil, model.ErrRecordNotFound
+	}
+
+	return app.models.Reservations.GetForUser(int64(reservationID), int64(storeID), app.contextGetUser(r).ID)
+}
@@ -30,19 +30,111 @@ func (app *application) routes() http.Handler {
 	prod1.HandleFunc("/products", app.createProductsHandler).Methods("POST")
 	// Get a specific prod
 	prod1.HandleFunc("/products/{id:[0-9]+}", app.getProductHandler).Methods("GET")
+	// Get the price history of a specific prod
+	prod1.HandleFunc("/products/{id:[0-9]+}/prices", app.getProductPricesHandler).Methods("GET")
 	// Update a specific prod
 	prod1.HandleFunc("/products/{id:[0-9]+}", app.updateProductHandler).Methods("PUT")
+	// Change some fields of a specific prod
+	prod1.HandleFunc("/products/{id:[0-9]+}", app.requirePermissions("products:write", app.patchProductHandler)).Methods("PATCH")
 	prod1.HandleFunc("/products/nopermission/{id:[0-9]+}", app.deleteProductHandler).Methods("DELETE")
 
 	// Delete a specific prod
 	prod1.HandleFunc("/products/{id:[0-9]+}", app.requirePermissions("products:write", app.deleteProductHandler)).Methods("DELETE")
+	// Take a deleted prod out of the trash
+	prod1.HandleFunc("/products/{id:[0-9]+}/restore", app.requirePermissions("products:write", app.restoreProductHandler)).Methods("POST")
+
+	// Product variants
+	prod1.HandleFunc("/products/{id:[0-9]+}/variants", app.getVariantsList).Methods("GET")
+	prod1.HandleFunc("/products/{id:[0-9]+}/variants", app.requirePermissions("products:write", app.createVariantHandler)).Methods("POST")
+	prod1.HandleFunc("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}", app.getVariantHandler).Methods("GET")
+	prod1.HandleFunc("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}", app.requirePermissions("products:write", app.updateVariantHandler)).Methods("PUT")
+	prod1.HandleFunc("/products/{id:[0-9]+}/variants/{variantId:[0-9]+}", app.requirePermissions("products:write", app.deleteVariantHandler)).Methods("DELETE")
+
+	// Bulk import and export
+	prod1.HandleFunc("/products/import", app.requirePermissions("products:write", app.importProductsHandler)).Methods("POST")
+	prod1.HandleFunc("/products/export", app.exportProductsHandler).Methods("GET")
+
+	// Product images
+	prod1.HandleFunc("/products/{id:[0-9]+}/images", app.getProductImagesList).Methods("GET")
+	prod1.HandleFunc("/products/{id:[0-9]+}/images", app.requirePermissions("products:write", app.uploadProductImageHandler)).Methods("POST")
+	prod1.HandleFunc("/products/{id:[0-9]+}/images/order", app.requirePermissions("products:write", app.reorderProductImagesHandler)).Methods("PUT")
+	prod1.HandleFunc("/products/{id:[0-9]+}/images/{imageId:[0-9]+}/primary", app.requirePermissions("products:write", app.setPrimaryProductImageHandler)).Methods("PUT")
+	prod1.HandleFunc("/products/{id:[0-9]+}/images/{imageId:[0-9]+}", app.requirePermissions("products:write", app.deleteProductImageHandler)).Methods("DELETE")
+	prod1.HandleFunc("/products/{id:[0-9]+}/images/{imageId:[0-9]+}/file", app.serveProductImageHandler(false)).Methods("GET")
+	prod1.HandleFunc("/products/{id:[0-9]+}/images/{imageId:[0-9]+}/thumbnail", app.serveProductImageHandler(true)).Methods("GET")
 
 	//Stores
 	store.HandleFunc("/stores", app.getStoresList).Methods("GET")
 	store.HandleFunc("/stores", app.createStoresHandler).Methods("POST")
+	store.HandleFunc("/stores/nearby", app.getNearbyStoresList).Methods("GET")
+	store.HandleFunc("/stores/export", app.exportStoresHandler).Methods("GET")
 	store.HandleFunc("/stores/{id:[0-9]+}", app.getStoreHandler).Methods("GET")
 	store.HandleFunc("/stores/{id:[0-9]+}", app.updateStoreHandler).Methods("PUT")
+	store.HandleFunc("/stores/{id:[0-9]+}", app.requirePermissions("products:write", app.patchStoreHandler)).Methods("PATCH")
 	store.HandleFunc("/stores/{id:[0-9]+}", app.requirePermissions("products:write", app.deleteStoreHandler)).Methods("DELETE")
+	store.HandleFunc("/stores/{id:[0-9]+}/restore", app.requirePermissions("products:write", app.restoreStoreHandler)).Methods("POST")
+
+	// Store branches
+	store.HandleFunc("/stores/{id:[0-9]+}/branches", app.getBranchesList).Methods("GET")
+	store.HandleFunc("/stores/{id:[0-9]+}/branches", app.requirePermissions("products:write", app.createBranchHandler)).Methods("POST")
+	store.HandleFunc("/stores/{id:[0-9]+}/branches/{branchId:[0-9]+}", app.getBranchHandler).Methods("GET")
+	store.HandleFunc("/stores/{id:[0-9]+}/branches/{branchId:[0-9]+}", app.requirePermissions("products:write", app.updateBranchHandler)).Methods("PUT")
+	store.HandleFunc("/stores/{id:[0-9]+}/branches/{branchId:[0-9]+}", app.requirePermissions("products:write", app.deleteBranchHandler)).Methods("DELETE")
+
+	// Store opening hours
+	store.HandleFunc("/stores/{id:[0-9]+}/hours", app.getStoreHoursHandler).Methods("GET")
+	store.HandleFunc("/stores/{id:[0-9]+}/hours", app.requirePermissions("products:write", app.putStoreHoursHandler)).Methods("PUT")
+	store.HandleFunc("/stores/{id:[0-9]+}/hours/closures", app.requirePermissions("products:write", app.createStoreClosureHandler)).Methods("POST")
+	store.HandleFunc("/stores/{id:[0-9]+}/hours/closures/{closureId:[0-9]+}", app.requirePermissions("products:write", app.deleteStoreClosureHandler)).Methods("DELETE")
+
+	// Store inventory (stores_and_products)
+	store.HandleFunc("/stores/{id:[0-9]+}/products", app.getStoreProductsList).Methods("GET")
+	store.HandleFunc("/stores/{id:[0-9]+}/products/{productId:[0-9]+}", app.getStoreProductHandler).Methods("GET")
+	store.HandleFunc("/stores/{id:[0-9]+}/products/{productId:[0-9]+}", app.requirePermissions("products:write", app.putStoreProductHandler)).Methods("PUT")
+	store.HandleFunc("/stores/{id:[0-9]+}/products/{productId:[0-9]+}", app.requirePermissions("products:write", app.deleteStoreProductHandler)).Methods("DELETE")
+	store.HandleFunc("/stores/{id:[0-9]+}/products/{productId:[0-9]+}/variants/{variantId:[0-9]+}", app.getStoreProductHandler).Methods("GET")
+	store.HandleFunc("/stores/{id:[0-9]+}/products/{productId:[0-9]+}/variants/{variantId:[0-9]+}", app.requirePermissions("products:write", app.putStoreProductHandler)).Methods("PUT")
+	store.HandleFunc("/stores/{id:[0-9]+}/products/{productId:[0-9]+}/variants/{variantId:[0-9]+}", app.requirePermissions("products:write", app.deleteStoreProductHandler)).Methods("DELETE")
+	prod1.HandleFunc("/products/{id:[0-9]+}/stores", app.getProductStoresList).Methods("GET")
+
+	// Stock reservations
+	store.HandleFunc("/stores/{id:[0-9]+}/reservations", app.requireActivatedUser(app.createReservationHandler)).Methods("POST")
+	store.HandleFunc("/stores/{id:[0-9]+}/reservations/{reservationId:[0-9]+}", app.requireActivatedUser(app.getReservationHandler)).Methods("GET")
+	store.HandleFunc("/stores/{id:[0-9]+}/reservations/{reservationId:[0-9]+}", app.requireActivatedUser(app.releaseReservationHandler)).Methods("DELETE")
+
+	// Categories
+	categories := r.PathPrefix("/api/v1").Subrouter()
+	categories.HandleFunc("/categories", app.getCategoriesTree).Methods("GET")
+	categories.HandleFunc("/categories", app.requirePermissions("products:write", app.createCategoryHandler)).Methods("POST")
+	categories.HandleFunc("/categories/{id:[0-9]+}", app.getCategoryHandler).Methods("GET")
+	categories.HandleFunc("/categories/{id:[0-9]+}", app.requirePermissions("products:write", app.updateCategoryHandler)).Methods("PUT")
+	categories.HandleFunc("/categories/{id:[0-9]+}", app.requirePermissions("products:write", app.deleteCategoryHandler)).Methods("DELETE")
+
+	// Trash of the deleted products and stores
+	trash := r.PathPrefix("/api/v1").Subrouter()
+	trash.HandleFunc("/trash", app.requirePermissions("trash:read", app.getTrashHandler)).Methods("GET")
+
+	// Audit log
+	audit := r.PathPrefix("/api/v1").Subrouter()
+	audit.HandleFunc("/audit", app.requirePermissions("audit:read", app.getAuditLogHandler)).Methods("GET")
+
+	// Search autocomplete
+	search := r.PathPrefix("/api/v1").Subrouter()
+	search.HandleFunc("/search/suggest", app.getSuggestionsHandler).Methods("GET")
+
+	// Orders
+	orders := r.PathPrefix("/api/v1").Subrouter()
+	orders.HandleFunc("/orders", app.requireActivatedUser(app.getOrdersList)).Methods("GET")
+	orders.HandleFunc("/orders", app.requireActivatedUser(app.createOrderHandler)).Methods("POST")
+	orders.HandleFunc("/orders/{id:[0-9]+}", app.requireActivatedUser(app.getOrderHandler)).Methods("GET")
+	orders.HandleFunc("/orders/{id:[0-9]+}/events", app.requireActivatedUser(app.getOrderEventsHandler)).Methods("GET")
+	orders.HandleFunc("/orders/{id:[0-9]+}/status", app.requirePermissions("orders:write", app.updateOrderStatusHandler)).Methods("PATCH")
+
+	// Exchange rates
+	rates := r.PathPrefix("/api/v1").Subrouter()
+	rates.HandleFunc("/exchange-rates", app.getExchangeRatesList).Methods("GET")
+	rates.HandleFunc("/exchange-rates/{currency:[A-Za-z]{3}}", app.requirePermissions("exchange_rates:write", app.putExchangeRateHandler)).Methods("PUT")
+	rates.HandleFunc("/exchange-rates/{currency:[A-Za-z]{3}}", app.requirePermissions("exchange_rates:write", app.deleteExchangeRateHandler)).Methods("DELETE")
 
 	users1 := r.PathPrefix("/api/v1").Subrouter()
 	// User handlers with Authentication
@@ -51,5 +143,5 @@ func (app *application) routes() http.Handler {
 	users1.HandleFunc("/users/login", app.createAuthenticationTokenHandler).Methods("POST")
 
 	// Wrap the router with the panic recovery middleware and rate limit middleware.
-	return app.authenticate(r)
+	return app.requestID(app.authenticate(r))
 }
@@ -27,6 +27,10 @@ func (app *application) serve() error {
 	// by the graceful Shutdown() function.
 	shutdownError := make(chan error)
 
+	// Start the background jobs. Closing the done channel stops them on shutdown.
+	done := make(chan struct{})
+	app.startJobs(done)
+
 	// Start a background goroutine.
 	go func() {
 		// Create a quit channel which carries os.Signal values. Use buffered
@@ -65,6 +69,9 @@ func (app *application) serve() error {
 			"addr": srv.Addr,
 		})
 
+		// Stop the background jobs before waiting for them.
+		close(done)
+
 		// Call Wait() to block until our WaitGroup counter is zero. This essentially blocks
 		// until the background goroutines have finished. Then we return nil on the shutdownError
 		// channel to indicate that the shutdown as compleeted without any issues.
@@ -6,15 +6,18 @@ import (
 	"github.com/kim0111/GoMidterm/pkg/apple/validator"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
 func (app *application) createStoresHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title            string `json:"title"`
-		Description      string `json:"description"`
-		Address          string `json:"address"`
-		Coordinates      string `json:"coordinates"`
-		NumberOfBranches uint   `json:"numberOfBranches"`
+		Title       string   `json:"title"`
+		Description string   `json:"description"`
+		Address     string   `json:"address"`
+		Latitude    *float64 `json:"latitude"`
+		Longitude   *float64 `json:"longitude"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -25,38 +28,41 @@ func (app *application) createStoresHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	store := &model.Store{
-		Title:            input.Title,
-		Description:      input.Description,
-		Address:          input.Address,
-		Coordinates:      input.Coordinates,
-		NumberOfBranches: input.NumberOfBranches,
+		Title:       input.Title,
+		Description: input.Description,
+		Address:     input.Address,
+		Latitude:    input.Latitude,
+		Longitude:   input.Longitude,
 	}
 
-	err = app.models.Stores.Insert(store)
+	v := validator.New()
+
+	if model.ValidateStore(v, store); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Stores.Insert(store, app.auditEntry(r, model.AuditCreate, model.AuditStore, nil))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusCreated, envelope{"stores": store}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", etag(store.Version))
+
+	app.writeJSON(w, http.StatusCreated, envelope{"stores": store}, headers)
 }
 
 func (app *application) getStoresList(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title        string
-		BranchesFrom int
-		BranchesTo   int
+		model.StoreQuery
 		model.Filters
 	}
 	v := validator.New()
 	qs := r.URL.Query()
 
-	// Use our helpers to extract the title and nutrition value range query string values, falling back to the
-	// defaults of an empty string and an empty slice, respectively, if they are not provided
-	// by the client.
-	input.Title = app.readStrings(qs, "title", "")
-	input.BranchesFrom = app.readInt(qs, "branchesFrom", 0, v)
-	input.BranchesTo = app.readInt(qs, "branchesTo", 0, v)
+	input.StoreQuery = app.readStoreQuery(qs, v)
 
 	// Ge the page and page_size query string value as integers. Notice that we set the default
 	// page value to 1 and default page_size to 20, and that we pass the validator instance
@@ -64,24 +70,110 @@ func (app *application) getStoresList(w http.ResponseWriter, r *http.Request) {
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
+	// How the total number of stores is found. An exact count reads every matching store on every
+	// page, which "estimated" or "none" avoid for large listings.
+	input.Filters.Count = app.readStrings(qs, "count", model.CountExact)
+
 	// Extract the sort query string value, falling back to "id" if it is not provided
-	// by the client (which will imply an ascending sort on store ID).
-	input.Filters.Sort = app.readStrings(qs, "sort", "id")
+	// by the client (which will imply an ascending sort on store ID). Search results are sorted
+	// by relevance by default.
+	input.Filters.Sort = app.readStrings(qs, "sort", defaultSort(input.Query))
 
 	// Add the supported sort value for this endpoint to the sort safelist.
-	// name of the column in the database.
-	input.Filters.SortSafeList = []string{
-		// ascending sort values
-		"id", "title", "numberOfBranches",
-		// descending sort values
-		"-id", "-title", "-numberOfBranches",
-	}
+	input.Filters.SortSafeList = storeSortSafeList
+
+	// The fields to limit the stores to, e.g. "id,title,address", and the related records to
+	// embed into them.
+	input.Filters.Fields = app.readCSV(qs, "fields", nil)
+	input.Filters.FieldSafeList = storeFieldSafeList
+	include := app.readInclude(qs, v, "products")
+
+	// A cursor pages through the stores by position, which stays fast and stable however deep
+	// the client pages.
+	fingerprint := cursorQuery(qs, input.Filters.Sort)
+	input.Filters.Cursor = app.readCursor(qs, fingerprint, v)
 
+	model.ValidateSearch(v, input.Query, input.Filters)
 	if model.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	stores, metadata, err := app.models.Stores.GetAll(input.Title, input.BranchesFrom, input.BranchesTo, input.Filters)
+	stores, metadata, err := app.models.Stores.GetAll(input.StoreQuery, input.Filters)
+	if err == nil && (wantsField(input.Filters.Fields, "openNow") || wantsField(input.Filters.Fields, "nextOpening")) {
+		err = app.setOpenStatus(input.OpenAt, stores...)
+	}
+	if err == nil && validator.In("products", include...) {
+		err = app.embedProducts(stores...)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.setCursors(&metadata, fingerprint)
+
+	data, err := pickFields(stores, input.Filters.Fields, include)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"stores": data, "metadata": metadata}, nil)
+}
+
+// storeSortSafeList holds the sort values of the store list and export.
+var storeSortSafeList = []string{
+	// ascending sort values
+	"id", "title", "numberOfBranches", model.SortRelevance,
+	// descending sort values
+	"-id", "-title", "-numberOfBranches",
+}
+
+// readStoreQuery reads the store filters shared by the store list and export.
+func (app *application) readStoreQuery(qs url.Values, v *validator.Validator) model.StoreQuery {
+	// Use our helpers to extract the title and nutrition value range query string values, falling back to the
+	// defaults of an empty string and an empty slice, respectively, if they are not provided
+	// by the client.
+	var q model.StoreQuery
+	q.Title = app.readStrings(qs, "title", "")
+	q.BranchesFrom = app.readInt(qs, "branchesFrom", 0, v)
+	q.BranchesTo = app.readInt(qs, "branchesTo", 0, v)
+	q.Query = strings.TrimSpace(app.readStrings(qs, "q", ""))
+	q.OpenNow = app.readBool(qs, "open_now", v)
+	q.OpenAt = time.Now()
+
+	return q
+}
+
+// getNearbyStoresList returns the stores within radius_km (10 by default) of the lat/lon point,
+// closest first.
+func (app *application) getNearbyStoresList(w http.ResponseWriter, r *http.Request) {
+	var filters model.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	for _, key := range []string{"lat", "lon"} {
+		v.Check(qs.Get(key) != "", key, "must be provided")
+	}
+	lat := app.readFloat(qs, "lat", 0, v)
+	lon := app.readFloat(qs, "lon", 0, v)
+	radiusKm := app.readFloat(qs, "radius_km", 10, v)
+
+	// The results are always ordered by distance, so there is nothing to sort by.
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = "distance"
+	filters.SortSafeList = []string{"distance"}
+
+	model.ValidateNearbyQuery(v, lat, lon, radiusKm)
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stores, metadata, err := app.models.Stores.Nearby(lat, lon, radiusKm, filters)
+	if err == nil {
+		err = app.setOpenStatus(time.Now(), stores...)
+	}
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -97,7 +189,18 @@ func (app *application) getStoreHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	store, err := app.models.Stores.Get(id)
+	v := validator.New()
+	qs := r.URL.Query()
+
+	fields := app.readCSV(qs, "fields", nil)
+	include := app.readInclude(qs, v, "products")
+
+	if model.ValidateFields(v, fields, storeFieldSafeList); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	store, err := app.models.Stores.GetFields(id, fields)
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrRecordNotFound):
@@ -108,9 +211,60 @@ func (app *application) getStoreHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, envelope{"stores": store}, nil)
+	if wantsField(fields, "openNow") || wantsField(fields, "nextOpening") {
+		err = app.setOpenStatus(time.Now(), store)
+	}
+	if err == nil && validator.In("products", include...) {
+		err = app.embedProducts(store)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data, err := pickFields(store, fields, include)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(store.Version))
+
+	app.writeJSON(w, http.StatusOK, envelope{"stores": data}, headers)
+}
+
+// storeInput is the part of a store a client writes: a PUT replaces it as a whole and a PATCH is
+// applied to it.
+type storeInput struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Address     string   `json:"address"`
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
+}
+
+func newStoreInput(store *model.Store) storeInput {
+	return storeInput{
+		Title:       store.Title,
+		Description: store.Description,
+		Address:     store.Address,
+		Latitude:    store.Latitude,
+		Longitude:   store.Longitude,
+	}
 }
 
+// applyTo replaces the written fields of the store with the input.
+func (input storeInput) applyTo(store *model.Store) {
+	store.Title = input.Title
+	store.Description = input.Description
+	store.Address = input.Address
+	store.Latitude = input.Latitude
+	store.Longitude = input.Longitude
+}
+
+// updateStoreHandler replaces a store. Fields left out of the body are cleared, so the body must
+// hold the whole store; PATCH changes only some of its fields.
 func (app *application) updateStoreHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -129,40 +283,62 @@ func (app *application) updateStoreHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	var input struct {
-		Title            string `json:"title"`
-		Description      string `json:"description"`
-		Address          string `json:"address"`
-		Coordinates      string `json:"coordinates"`
-		NumberOfBranches uint   `json:"numberOfBranches"`
+	if !app.checkIfMatch(w, r, store.Version) {
+		return
 	}
 
+	before := *store
+
+	var input storeInput
 	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	if &input.Title != nil {
-		store.Title = input.Title
-	}
+	input.applyTo(store)
+
+	app.saveStore(w, r, store, &before)
+}
 
-	if &input.Description != nil {
-		store.Description = input.Description
+// patchStoreHandler changes some fields of a store with a JSON Merge Patch or a JSON Patch.
+func (app *application) patchStoreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
 	}
 
-	if &input.Address != nil {
-		store.Address = input.Address
+	store, err := app.models.Stores.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
-	if &input.Coordinates != nil {
-		store.Coordinates = input.Coordinates
+	if !app.checkIfMatch(w, r, store.Version) {
+		return
 	}
 
-	if &input.NumberOfBranches != nil {
-		store.NumberOfBranches = input.NumberOfBranches
+	before := *store
+
+	var input storeInput
+	if !app.applyPatch(w, r, newStoreInput(store), &input) {
+		return
 	}
 
+	input.applyTo(store)
+
+	app.saveStore(w, r, store, &before)
+}
+
+// saveStore validates and saves a changed store and sends it back. before is the store as it was
+// loaded, for the audit log.
+func (app *application) saveStore(w http.ResponseWriter, r *http.Request, store, before *model.Store) {
 	v := validator.New()
 
 	if model.ValidateStore(v, store); !v.Valid() {
@@ -170,9 +346,11 @@ func (app *application) updateStoreHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Stores.Update(store)
+	err := app.models.Stores.Update(store, app.auditEntry(r, model.AuditUpdate, model.AuditStore, before))
 	if err != nil {
 		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		case errors.Is(err, model.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
@@ -181,7 +359,10 @@ func (app *application) updateStoreHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, envelope{"stores": store}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", etag(store.Version))
+
+	app.writeJSON(w, http.StatusOK, envelope{"stores": store}, headers)
 }
 
 func (app *application) deleteStoreHandler(w http.ResponseWriter, r *http.Request) {
@@ -191,9 +372,27 @@ func (app *application) deleteStoreHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Stores.Delete(id)
+	// Read the store first, so the audit log can tell what was deleted.
+	store, err := app.models.Stores.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkIfMatch(w, r, store.Version) {
+		return
+	}
+
+	err = app.models.Stores.Delete(id, store.Version, app.auditEntry(r, model.AuditDelete, model.AuditStore, store))
 	if err != nil {
 		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		case errors.Is(err, model.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+func (app *application) getStoreHoursHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	hours, err := app.models.StoreHours.Get(int64(storeID))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"hours": hours}, nil)
+}
+
+// putStoreHoursHandler replaces the time zone and the weekly opening hours of a store. The special
+// closures are managed separately.
+func (app *application) putStoreHoursHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		TimeZone string                `json:"timeZone"`
+		Weekly   []model.OpeningPeriod `json:"weekly"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	hours := &model.StoreHours{
+		TimeZone: input.TimeZone,
+		Weekly:   input.Weekly,
+	}
+
+	v := validator.New()
+
+	if model.ValidateStoreHours(v, hours); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.StoreHours.SetWeekly(int64(storeID), hours)
+	if err == nil {
+		hours, err = app.models.StoreHours.Get(int64(storeID))
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"hours": hours}, nil)
+}
+
+func (app *application) createStoreClosureHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		StartsOn string `json:"startsOn"`
+		EndsOn   string `json:"endsOn"`
+		Reason   string `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Closures can't be added to a store in the trash.
+	_, err = app.models.Stores.Get(storeID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	closure := &model.Closure{
+		StoreID:  int64(storeID),
+		StartsOn: input.StartsOn,
+		EndsOn:   input.EndsOn,
+		Reason:   input.Reason,
+	}
+
+	v := validator.New()
+
+	if model.ValidateClosure(v, closure); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.StoreHours.InsertClosure(closure)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"closure": closure}, nil)
+}
+
+func (app *application) deleteStoreClosureHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	closureID, err := app.readIntParam(r, "closureId")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.StoreHours.DeleteClosure(int64(storeID), int64(closureID))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+// setOpenStatus fills in whether the stores are open at now and when they open next.
+func (app *application) setOpenStatus(now time.Time, stores ...*model.Store) error {
+	ids := make([]int64, 0, len(stores))
+	for _, store := range stores {
+		id, err := strconv.ParseInt(store.Id, 10, 64)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	schedules, err := app.models.StoreHours.Schedules(ids, now)
+	if err != nil {
+		return err
+	}
+
+	for i, store := range stores {
+		schedule, ok := schedules[ids[i]]
+		if !ok {
+			continue
+		}
+
+		open := schedule.OpenAt(now)
+		store.OpenNow = &open
+		store.NextOpening = schedule.NextOpening(now)
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// getTrashHandler returns the deleted products and stores, most recently deleted first. The type
+// query parameter limits them to "product" or "store".
+func (app *application) getTrashHandler(w http.ResponseWriter, r *http.Request) {
+	var filters model.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	itemType := app.readStrings(qs, "type", "")
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readStrings(qs, "sort", "-deletedAt")
+	filters.SortSafeList = []string{"deletedAt", "-deletedAt"}
+
+	model.ValidateTrashType(v, itemType)
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	items, metadata, err := app.models.Trash.GetAll(itemType, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if retention := app.config.trash.retention; retention > 0 {
+		for _, item := range items {
+			purgeAt := item.DeletedAt.Add(retention)
+			item.PurgeAt = &purgeAt
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"trash": items, "metadata": metadata}, nil)
+}
+
+// restoreProductHandler takes a product out of the trash.
+func (app *application) restoreProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	product, err := app.models.Products.Restore(id, app.auditEntry(r, model.AuditRestore, model.AuditProduct, nil))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"products": product}, nil)
+}
+
+// restoreStoreHandler takes a store out of the trash.
+func (app *application) restoreStoreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	store, err := app.models.Stores.Restore(id, app.auditEntry(r, model.AuditRestore, model.AuditStore, nil))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"stores": store}, nil)
+}
+
+// purgeTrash deletes the products and stores that have been in the trash for longer than the
+// retention period for good, and removes the image files of the purged products.
+func (app *application) purgeTrash() error {
+	result, err := app.models.Trash.Purge(time.Now().Add(-app.config.trash.retention))
+	if err != nil {
+		return err
+	}
+
+	app.deleteImageFiles(result.Images...)
+
+	if result.Products > 0 || result.Stores > 0 {
+		app.logger.PrintInfo("purged trash", map[string]string{
+			"products": strconv.Itoa(result.Products),
+			"stores":   strconv.Itoa(result.Stores),
+		})
+	}
+
+	return nil
+}
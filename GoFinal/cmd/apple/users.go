@@ -51,7 +51,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Insert the user data into the database.
-	err = app.models.Users.Insert(user)
+	err = app.models.Users.Insert(user, app.auditEntry(r, model.AuditCreate, model.AuditUser, nil))
 	if err != nil {
 		switch {
 		// If we get an ErrDuplicateEmail error, use the v.AddError() method to manually add
@@ -67,7 +67,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "products:read")
+	err = app.models.Permissions.AddForUser(user.ID, app.auditEntry(r, model.AuditGrant, model.AuditPermission, nil), "products:read")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -129,12 +129,14 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	before := *user
+
 	// Update the user's activation status.
 	user.Activated = true
 
 	// Save the updated user record in our database, checking for any edit conflicts in the same
 	// way that we did for our move records.
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(user, app.auditEntry(r, model.AuditActivate, model.AuditUser, &before))
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrEditConflict):
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/model"
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+func (app *application) getVariantsList(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Make sure that the product exists, so an unknown product is a 404 instead of an empty list.
+	_, err = app.models.Products.Get(productID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	variants, err := app.models.Variants.GetAllForProduct(int64(productID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"variants": variants}, nil)
+}
+
+func (app *application) createVariantHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	product, err := app.models.Products.Get(productID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		SKU           string            `json:"sku"`
+		Options       map[string]string `json:"options"`
+		PriceOverride *model.Money      `json:"priceOverride"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	variant := &model.Variant{
+		ProductID:     int64(productID),
+		SKU:           input.SKU,
+		Options:       input.Options,
+		PriceOverride: input.PriceOverride,
+	}
+
+	v := validator.New()
+
+	if model.ValidateVariant(v, variant, product); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Variants.Insert(variant)
+	if err != nil {
+		app.variantErrorResponse(w, r, v, err)
+		return
+	}
+
+	// Read the variant back for its effective price and stock.
+	variant, err = app.models.Variants.Get(variant.ProductID, variant.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"variant": variant}, nil)
+}
+
+func (app *application) getVariantHandler(w http.ResponseWriter, r *http.Request) {
+	productID, variantID, err := app.readVariantIDs(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	variant, err := app.models.Variants.Get(productID, variantID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"variant": variant}, nil)
+}
+
+// updateVariantHandler replaces the SKU, options and price override of a variant. A
+// "priceOverride" of null makes the variant sell for the product price again.
+func (app *application) updateVariantHandler(w http.ResponseWriter, r *http.Request) {
+	productID, variantID, err := app.readVariantIDs(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	product, err := app.models.Products.Get(int(productID))
+	if err == nil {
+		_, err = app.models.Variants.Get(productID, variantID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		SKU           string            `json:"sku"`
+		Options       map[string]string `json:"options"`
+		PriceOverride *model.Money      `json:"priceOverride"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	variant := &model.Variant{
+		ID:            variantID,
+		ProductID:     productID,
+		SKU:           input.SKU,
+		Options:       input.Options,
+		PriceOverride: input.PriceOverride,
+	}
+
+	v := validator.New()
+
+	if model.ValidateVariant(v, variant, product); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Variants.Update(variant)
+	if err != nil {
+		app.variantErrorResponse(w, r, v, err)
+		return
+	}
+
+	variant, err = app.models.Variants.Get(productID, variantID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"variant": variant}, nil)
+}
+
+// deleteVariantHandler removes a variant together with its stock in every store. Orders keep the
+// snapshotted SKU.
+func (app *application) deleteVariantHandler(w http.ResponseWriter, r *http.Request) {
+	productID, variantID, err := app.readVariantIDs(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Variants.Delete(productID, variantID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+// readVariantIDs reads the interpolated "id" of the product and "variantId" from the request URL.
+func (app *application) readVariantIDs(r *http.Request) (int64, int64, error) {
+	productID, err := app.readIDParam(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	variantID, err := app.readIntParam(r, "variantId")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int64(productID), int64(variantID), nil
+}
+
+// variantErrorResponse answers a failed insert or update of a variant.
+func (app *application) variantErrorResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, model.ErrDuplicateSKU):
+		v.AddError("sku", "a variant with this SKU already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, model.ErrDuplicateOptions):
+		v.AddError("options", "another variant of the product already has these options")
+		app.failedValidationResponse(w, r, v.Errors)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
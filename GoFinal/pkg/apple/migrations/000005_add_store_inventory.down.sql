@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS stores_and_products_product_idx;
+ALTER TABLE stores_and_products DROP CONSTRAINT IF EXISTS stores_and_products_store_product_key;
+ALTER TABLE stores_and_products DROP COLUMN IF EXISTS quantity;
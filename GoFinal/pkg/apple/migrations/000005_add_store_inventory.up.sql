@@ -0,0 +1,8 @@
+ALTER TABLE stores_and_products
+    ADD COLUMN IF NOT EXISTS quantity int NOT NULL DEFAULT 0 CHECK (quantity >= 0);
+
+-- A store carries a product at most once, the quantity is kept on that single row.
+ALTER TABLE stores_and_products
+    ADD CONSTRAINT stores_and_products_store_product_key UNIQUE (store, product);
+
+CREATE INDEX IF NOT EXISTS stores_and_products_product_idx ON stores_and_products (product);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS reservations
+(
+    id          bigserial PRIMARY KEY,
+    created_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    store_id    bigint                      NOT NULL REFERENCES stores ON DELETE CASCADE,
+    product_id  bigint                      NOT NULL REFERENCES products ON DELETE CASCADE,
+    user_id     bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    quantity    int                         NOT NULL CHECK (quantity > 0),
+    status      text                        NOT NULL DEFAULT 'active',
+    expires_at  timestamp(0) with time zone NOT NULL,
+    released_at timestamp(0) with time zone
+);
+
+-- The expiry sweep only ever looks at active reservations.
+CREATE INDEX IF NOT EXISTS reservations_active_expires_at_idx ON reservations (expires_at) WHERE status = 'active';
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS orders
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    store_id   bigint                      REFERENCES stores ON DELETE SET NULL,
+    status     text                        NOT NULL DEFAULT 'pending',
+    total      bigint                      NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS orders_user_id_idx ON orders (user_id);
+
+-- Order lines keep a snapshot of the product title and price at checkout time, so the order stays
+-- intact when the product is changed or deleted later on.
+CREATE TABLE IF NOT EXISTS order_lines
+(
+    id         bigserial PRIMARY KEY,
+    order_id   bigint NOT NULL REFERENCES orders ON DELETE CASCADE,
+    product_id bigint REFERENCES products ON DELETE SET NULL,
+    title      text   NOT NULL,
+    quantity   int    NOT NULL CHECK (quantity > 0),
+    unit_price bigint NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_lines_order_id_idx ON order_lines (order_id);
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'orders:write';
+DROP TABLE IF EXISTS order_events;
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
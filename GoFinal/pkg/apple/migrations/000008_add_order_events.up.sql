@@ -0,0 +1,20 @@
+ALTER TABLE orders
+    ADD CONSTRAINT orders_status_check CHECK (status IN
+        ('pending', 'paid', 'ready_for_pickup', 'shipped', 'completed', 'cancelled', 'refunded'));
+
+-- order_events is the history of the status changes of an order.
+CREATE TABLE IF NOT EXISTS order_events
+(
+    id          bigserial PRIMARY KEY,
+    created_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    order_id    bigint                      NOT NULL REFERENCES orders ON DELETE CASCADE,
+    from_status text,
+    to_status   text                        NOT NULL,
+    actor_id    bigint                      REFERENCES users ON DELETE SET NULL,
+    note        text                        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS order_events_order_id_idx ON order_events (order_id);
+
+INSERT INTO permissions (code)
+VALUES ('orders:write');
@@ -0,0 +1,16 @@
+-- product_prices is the price history of the products. A row is written whenever the price of a
+-- product is set, the price in effect at a moment is the latest row with valid_from before it.
+CREATE TABLE IF NOT EXISTS product_prices
+(
+    id         bigserial PRIMARY KEY,
+    product_id bigint                   NOT NULL REFERENCES products ON DELETE CASCADE,
+    price      int                      NOT NULL,
+    valid_from timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS product_prices_product_id_valid_from_idx ON product_prices (product_id, valid_from);
+
+-- The current prices are the only history we have of the existing products.
+INSERT INTO product_prices (product_id, price, valid_from)
+SELECT id, COALESCE(price, 0), created_at
+FROM products;
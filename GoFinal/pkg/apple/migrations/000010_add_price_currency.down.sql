@@ -0,0 +1,16 @@
+DELETE FROM permissions WHERE code = 'exchange_rates:write';
+DROP TABLE IF EXISTS exchange_rates;
+
+UPDATE order_lines SET unit_price = unit_price / 100;
+UPDATE orders SET total = total / 100;
+ALTER TABLE orders DROP COLUMN IF EXISTS currency;
+
+ALTER TABLE product_prices
+    ALTER COLUMN price TYPE int USING price / CASE currency WHEN 'JPY' THEN 1 ELSE 100 END;
+ALTER TABLE product_prices DROP COLUMN IF EXISTS currency;
+
+ALTER TABLE products
+    ALTER COLUMN price DROP NOT NULL,
+    ALTER COLUMN price DROP DEFAULT,
+    ALTER COLUMN price TYPE int USING price / CASE currency WHEN 'JPY' THEN 1 ELSE 100 END;
+ALTER TABLE products DROP COLUMN IF EXISTS currency;
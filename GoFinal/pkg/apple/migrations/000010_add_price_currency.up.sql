@@ -0,0 +1,46 @@
+-- Prices become amounts in the minor units of an ISO 4217 currency. The currency of the existing
+-- products is derived from the country they are made for, everything else is priced in USD.
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS currency char(3) NOT NULL DEFAULT 'USD';
+
+UPDATE products
+SET currency = CASE UPPER(for_what_country) WHEN 'JP' THEN 'JPY' WHEN 'EU' THEN 'EUR' ELSE 'USD' END;
+
+ALTER TABLE products
+    ALTER COLUMN price TYPE bigint USING COALESCE(price, 0) * CASE currency WHEN 'JPY' THEN 1 ELSE 100 END,
+    ALTER COLUMN price SET DEFAULT 0,
+    ALTER COLUMN price SET NOT NULL;
+
+ALTER TABLE product_prices
+    ADD COLUMN IF NOT EXISTS currency char(3) NOT NULL DEFAULT 'USD';
+
+UPDATE product_prices pp
+SET currency = p.currency
+FROM products p
+WHERE p.id = pp.product_id;
+
+ALTER TABLE product_prices
+    ALTER COLUMN price TYPE bigint USING price * CASE currency WHEN 'JPY' THEN 1 ELSE 100 END;
+
+-- Existing orders were placed in whole dollars.
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS currency char(3) NOT NULL DEFAULT 'USD';
+
+UPDATE orders SET total = total * 100;
+UPDATE order_lines SET unit_price = unit_price * 100;
+
+-- exchange_rates holds the number of units of a currency one US dollar buys. It is maintained by
+-- the admins, the USD row is fixed at 1.
+CREATE TABLE IF NOT EXISTS exchange_rates
+(
+    currency   char(3) PRIMARY KEY,
+    rate       numeric(20, 10)             NOT NULL CHECK (rate > 0),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO exchange_rates (currency, rate)
+VALUES ('USD', 1)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO permissions (code)
+VALUES ('exchange_rates:write');
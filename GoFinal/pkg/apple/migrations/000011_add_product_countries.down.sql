@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS products_countries_idx;
+
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS for_what_country text;
+
+UPDATE products
+SET for_what_country = array_to_string(countries, ',');
+
+ALTER TABLE products DROP COLUMN IF EXISTS countries;
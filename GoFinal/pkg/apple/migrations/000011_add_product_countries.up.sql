@@ -0,0 +1,73 @@
+-- for_what_country was free text ("USA", "EU", "CH", ...). It is replaced by a list of
+-- ISO 3166-1 alpha-2 codes and named regions such as "EU".
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS countries text[] NOT NULL DEFAULT '{}';
+
+-- The known names are mapped to their codes, anything else is taken as a code. Only codes of the
+-- ISO 3166-1 list and the regions of model/countries.go are kept, the same ones ValidateProduct
+-- accepts, so "XX" is not taken for a country and ends up among the unmapped values below.
+UPDATE products p
+SET countries = ARRAY [m.code]
+FROM (
+    SELECT id,
+        CASE UPPER(TRIM(for_what_country))
+            WHEN 'USA' THEN 'US'
+            WHEN 'UK' THEN 'GB'
+            WHEN 'JAPAN' THEN 'JP'
+            WHEN 'CHINA' THEN 'CN'
+            WHEN 'KOREA' THEN 'KR'
+            WHEN 'KAZAKHSTAN' THEN 'KZ'
+            WHEN 'EUROPE' THEN 'EU'
+            ELSE UPPER(TRIM(for_what_country))
+        END AS code
+    FROM products
+) AS m
+WHERE m.id = p.id
+    AND m.code = ANY (ARRAY [
+        'EU', 'EEA',
+        'AD', 'AE', 'AF', 'AG', 'AI', 'AL', 'AM', 'AO', 'AQ', 'AR', 'AS', 'AT', 'AU', 'AW', 'AX', 'AZ',
+        'BA', 'BB', 'BD', 'BE', 'BF', 'BG', 'BH', 'BI', 'BJ', 'BL', 'BM', 'BN', 'BO', 'BQ', 'BR', 'BS',
+        'BT', 'BV', 'BW', 'BY', 'BZ', 'CA', 'CC', 'CD', 'CF', 'CG', 'CH', 'CI', 'CK', 'CL', 'CM', 'CN',
+        'CO', 'CR', 'CU', 'CV', 'CW', 'CX', 'CY', 'CZ', 'DE', 'DJ', 'DK', 'DM', 'DO', 'DZ', 'EC', 'EE',
+        'EG', 'EH', 'ER', 'ES', 'ET', 'FI', 'FJ', 'FK', 'FM', 'FO', 'FR', 'GA', 'GB', 'GD', 'GE', 'GF',
+        'GG', 'GH', 'GI', 'GL', 'GM', 'GN', 'GP', 'GQ', 'GR', 'GS', 'GT', 'GU', 'GW', 'GY', 'HK', 'HM',
+        'HN', 'HR', 'HT', 'HU', 'ID', 'IE', 'IL', 'IM', 'IN', 'IO', 'IQ', 'IR', 'IS', 'IT', 'JE', 'JM',
+        'JO', 'JP', 'KE', 'KG', 'KH', 'KI', 'KM', 'KN', 'KP', 'KR', 'KW', 'KY', 'KZ', 'LA', 'LB', 'LC',
+        'LI', 'LK', 'LR', 'LS', 'LT', 'LU', 'LV', 'LY', 'MA', 'MC', 'MD', 'ME', 'MF', 'MG', 'MH', 'MK',
+        'ML', 'MM', 'MN', 'MO', 'MP', 'MQ', 'MR', 'MS', 'MT', 'MU', 'MV', 'MW', 'MX', 'MY', 'MZ', 'NA',
+        'NC', 'NE', 'NF', 'NG', 'NI', 'NL', 'NO', 'NP', 'NR', 'NU', 'NZ', 'OM', 'PA', 'PE', 'PF', 'PG',
+        'PH', 'PK', 'PL', 'PM', 'PN', 'PR', 'PS', 'PT', 'PW', 'PY', 'QA', 'RE', 'RO', 'RS', 'RU', 'RW',
+        'SA', 'SB', 'SC', 'SD', 'SE', 'SG', 'SH', 'SI', 'SJ', 'SK', 'SL', 'SM', 'SN', 'SO', 'SR', 'SS',
+        'ST', 'SV', 'SX', 'SY', 'SZ', 'TC', 'TD', 'TF', 'TG', 'TH', 'TJ', 'TK', 'TL', 'TM', 'TN', 'TO',
+        'TR', 'TT', 'TV', 'TW', 'TZ', 'UA', 'UG', 'UM', 'US', 'UY', 'UZ', 'VA', 'VC', 'VE', 'VG', 'VI',
+        'VN', 'VU', 'WF', 'WS', 'YE', 'YT', 'ZA', 'ZM', 'ZW'
+    ]);
+
+-- Products whose for_what_country was NULL or empty never said where they were made for. They are
+-- left with no countries, which only legacy rows can have: they match no country filter, and
+-- the countries have to be given the next time the product is saved.
+--
+-- Any other value that couldn't be mapped would be lost, so the migration fails and lists them
+-- instead; the file runs as a single transaction, so nothing has changed. Fix them up (e.g.
+-- "Deutschland" to "DE"), force the migration version back to 10 and migrate again.
+DO
+$$
+DECLARE
+    unmapped text;
+BEGIN
+    SELECT string_agg(DISTINCT for_what_country, ', ')
+    INTO unmapped
+    FROM products
+    WHERE countries = '{}'
+        AND TRIM(coalesce(for_what_country, '')) <> '';
+
+    IF unmapped IS NOT NULL THEN
+        RAISE EXCEPTION 'products.for_what_country values that are not a country or region: %', unmapped
+            USING HINT = 'Set them to a country code such as "US" or a region such as "EU" and migrate again.';
+    END IF;
+END
+$$;
+
+ALTER TABLE products DROP COLUMN IF EXISTS for_what_country;
+
+CREATE INDEX IF NOT EXISTS products_countries_idx ON products USING GIN (countries);
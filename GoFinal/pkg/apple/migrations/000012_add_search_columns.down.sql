@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS stores_search_idx;
+ALTER TABLE stores DROP COLUMN IF EXISTS search;
+
+DROP INDEX IF EXISTS products_search_idx;
+ALTER TABLE products DROP COLUMN IF EXISTS search;
@@ -0,0 +1,18 @@
+-- The search columns are kept up to date by Postgres itself. Titles weigh more than descriptions
+-- (and for stores, descriptions more than addresses) when the results are ranked.
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(description, '')), 'B')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS products_search_idx ON products USING GIN (search);
+
+ALTER TABLE stores
+    ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(description, '')), 'B') ||
+        setweight(to_tsvector('english', coalesce(address, '')), 'C')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS stores_search_idx ON stores USING GIN (search);
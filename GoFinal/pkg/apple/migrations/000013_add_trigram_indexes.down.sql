@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS stores_title_trgm_idx;
+DROP INDEX IF EXISTS products_title_trgm_idx;
@@ -0,0 +1,5 @@
+-- Trigram indexes back the typo tolerant title suggestions (word_similarity and the <% operator).
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS products_title_trgm_idx ON products USING GIN (title gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS stores_title_trgm_idx ON stores USING GIN (title gin_trgm_ops);
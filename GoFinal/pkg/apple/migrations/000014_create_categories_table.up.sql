@@ -0,0 +1,18 @@
+-- Categories form a tree (adjacency list): a category without a parent is a top level one.
+CREATE TABLE IF NOT EXISTS categories
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    parent_id  bigint REFERENCES categories (id) ON DELETE RESTRICT,
+    title      text                        NOT NULL,
+    slug       text                        NOT NULL UNIQUE,
+    CONSTRAINT categories_parent_check CHECK (parent_id <> id)
+);
+
+CREATE INDEX IF NOT EXISTS categories_parent_idx ON categories (parent_id);
+
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS category_id bigint REFERENCES categories (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS products_category_idx ON products (category_id);
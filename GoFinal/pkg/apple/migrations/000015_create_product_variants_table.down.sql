@@ -0,0 +1,21 @@
+ALTER TABLE order_lines
+    DROP COLUMN IF EXISTS sku,
+    DROP COLUMN IF EXISTS variant_id;
+
+ALTER TABLE reservations DROP COLUMN IF EXISTS variant_id;
+
+-- Variant stock can't be told apart from product stock any more.
+DELETE FROM stores_and_products WHERE variant_id IS NOT NULL;
+
+DROP INDEX IF EXISTS stores_and_products_variant_idx;
+DROP INDEX IF EXISTS stores_and_products_store_product_variant_key;
+
+ALTER TABLE stores_and_products
+    DROP CONSTRAINT IF EXISTS stores_and_products_variant_fkey;
+
+ALTER TABLE stores_and_products DROP COLUMN IF EXISTS variant_id;
+
+ALTER TABLE stores_and_products
+    ADD CONSTRAINT stores_and_products_store_product_key UNIQUE (store, product);
+
+DROP TABLE IF EXISTS product_variants;
@@ -0,0 +1,41 @@
+-- A variant is a concrete version of a product, e.g. the 256 GB black one. price and currency
+-- override the product price when set.
+CREATE TABLE IF NOT EXISTS product_variants
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    product_id bigint                      NOT NULL REFERENCES products ON DELETE CASCADE,
+    sku        text                        NOT NULL UNIQUE,
+    options    jsonb                       NOT NULL DEFAULT '{}',
+    price      bigint CHECK (price >= 0),
+    currency   text,
+    CONSTRAINT product_variants_price_check CHECK ((price IS NULL) = (currency IS NULL)),
+    -- Referenced together by stores_and_products, so a stock row can't mix up products and variants.
+    CONSTRAINT product_variants_product_id_id_key UNIQUE (product_id, id),
+    CONSTRAINT product_variants_product_id_options_key UNIQUE (product_id, options)
+);
+
+-- Stock is kept per variant: a row without a variant is the stock of the product itself.
+ALTER TABLE stores_and_products
+    ADD COLUMN IF NOT EXISTS variant_id bigint;
+
+ALTER TABLE stores_and_products
+    ADD CONSTRAINT stores_and_products_variant_fkey FOREIGN KEY (product, variant_id)
+        REFERENCES product_variants (product_id, id) ON DELETE CASCADE;
+
+ALTER TABLE stores_and_products
+    DROP CONSTRAINT IF EXISTS stores_and_products_store_product_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS stores_and_products_store_product_variant_key
+    ON stores_and_products (store, product, (coalesce(variant_id, 0)));
+
+CREATE INDEX IF NOT EXISTS stores_and_products_variant_idx ON stores_and_products (variant_id);
+
+ALTER TABLE reservations
+    ADD COLUMN IF NOT EXISTS variant_id bigint REFERENCES product_variants ON DELETE CASCADE;
+
+-- Like the title, the SKU is a snapshot taken at checkout time.
+ALTER TABLE order_lines
+    ADD COLUMN IF NOT EXISTS variant_id bigint REFERENCES product_variants ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS sku        text;
@@ -0,0 +1,21 @@
+-- The image files live in the file storage, the table only keeps their keys and metadata.
+CREATE TABLE IF NOT EXISTS product_images
+(
+    id            bigserial PRIMARY KEY,
+    created_at    timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    product_id    bigint                      NOT NULL REFERENCES products ON DELETE CASCADE,
+    content_type  text                        NOT NULL,
+    size          bigint                      NOT NULL,
+    width         int                         NOT NULL,
+    height        int                         NOT NULL,
+    checksum      text                        NOT NULL,
+    storage_key   text                        NOT NULL,
+    thumbnail_key text                        NOT NULL,
+    position      int                         NOT NULL,
+    is_primary    boolean                     NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS product_images_product_id_idx ON product_images (product_id, position);
+
+-- A product has at most one primary image.
+CREATE UNIQUE INDEX IF NOT EXISTS product_images_primary_key ON product_images (product_id) WHERE is_primary;
@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS stores_latitude_idx;
+
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS coordinates text NOT NULL DEFAULT '';
+UPDATE stores SET coordinates = latitude || ', ' || longitude WHERE latitude IS NOT NULL;
+ALTER TABLE stores ALTER COLUMN coordinates DROP DEFAULT;
+
+ALTER TABLE stores
+    DROP CONSTRAINT IF EXISTS stores_location_check,
+    DROP CONSTRAINT IF EXISTS stores_longitude_check,
+    DROP CONSTRAINT IF EXISTS stores_latitude_check,
+    DROP COLUMN IF EXISTS longitude,
+    DROP COLUMN IF EXISTS latitude;
@@ -0,0 +1,27 @@
+-- Coordinates used to be free text. They become numeric columns so the distance to a store can be
+-- computed. Text in the common "lat, lon" form is carried over, anything else leaves the store
+-- without a location.
+ALTER TABLE stores
+    ADD COLUMN IF NOT EXISTS latitude double precision,
+    ADD COLUMN IF NOT EXISTS longitude double precision;
+
+UPDATE stores
+SET latitude = parsed.m[1]::double precision, longitude = parsed.m[2]::double precision
+FROM (
+    SELECT id, regexp_match(coordinates, '^\s*(-?\d+(?:\.\d+)?)\s*[,; ]\s*(-?\d+(?:\.\d+)?)\s*$') AS m
+    FROM stores
+) AS parsed
+WHERE stores.id = parsed.id
+    AND parsed.m IS NOT NULL
+    AND parsed.m[1]::double precision BETWEEN -90 AND 90
+    AND parsed.m[2]::double precision BETWEEN -180 AND 180;
+
+ALTER TABLE stores DROP COLUMN IF EXISTS coordinates;
+
+ALTER TABLE stores
+    ADD CONSTRAINT stores_latitude_check CHECK (latitude BETWEEN -90 AND 90),
+    ADD CONSTRAINT stores_longitude_check CHECK (longitude BETWEEN -180 AND 180),
+    ADD CONSTRAINT stores_location_check CHECK ((latitude IS NULL) = (longitude IS NULL));
+
+-- Nearby searches narrow the stores down by a latitude band before computing exact distances.
+CREATE INDEX IF NOT EXISTS stores_latitude_idx ON stores (latitude);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS store_closures;
+DROP TABLE IF EXISTS store_hours;
+ALTER TABLE stores DROP COLUMN IF EXISTS time_zone;
@@ -0,0 +1,32 @@
+-- Opening hours are wall-clock times in the time zone of the store, so they stay right across
+-- daylight saving time changes.
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS time_zone text NOT NULL DEFAULT 'UTC';
+
+-- A period belongs to the weekday it starts on (0 is Sunday). Times are minutes since midnight, a
+-- period that closes at or before the time it opens runs past midnight.
+CREATE TABLE IF NOT EXISTS store_hours
+(
+    id         bigserial PRIMARY KEY,
+    store_id   bigint   NOT NULL REFERENCES stores (id) ON DELETE CASCADE,
+    weekday    smallint NOT NULL CHECK (weekday BETWEEN 0 AND 6),
+    opens_at   smallint NOT NULL CHECK (opens_at BETWEEN 0 AND 1439),
+    closes_at  smallint NOT NULL CHECK (closes_at BETWEEN 1 AND 1440),
+    CONSTRAINT store_hours_period_check CHECK (opens_at <> closes_at)
+);
+
+CREATE INDEX IF NOT EXISTS store_hours_store_id_idx ON store_hours (store_id, weekday);
+
+-- Special closures, e.g. holidays. The store doesn't open on any day of the range, both ends
+-- included.
+CREATE TABLE IF NOT EXISTS store_closures
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    store_id   bigint NOT NULL REFERENCES stores (id) ON DELETE CASCADE,
+    starts_on  date   NOT NULL,
+    ends_on    date   NOT NULL,
+    reason     text   NOT NULL DEFAULT '',
+    CONSTRAINT store_closures_range_check CHECK (starts_on <= ends_on)
+);
+
+CREATE INDEX IF NOT EXISTS store_closures_store_id_idx ON store_closures (store_id, ends_on);
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS store_branches_count ON store_branches;
+DROP FUNCTION IF EXISTS store_branches_count();
+DROP TABLE IF EXISTS store_branches;
+
+-- The counts typed in by clients come back in place of the counted ones.
+UPDATE stores SET number_of_branches = legacy_number_of_branches WHERE legacy_number_of_branches IS NOT NULL;
+ALTER TABLE stores DROP COLUMN IF EXISTS legacy_number_of_branches;
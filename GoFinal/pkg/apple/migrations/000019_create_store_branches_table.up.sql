@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS store_branches
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    store_id   bigint NOT NULL REFERENCES stores (id) ON DELETE CASCADE,
+    address    text   NOT NULL,
+    latitude   double precision,
+    longitude  double precision,
+    phone      text   NOT NULL DEFAULT '',
+    -- The weekly opening periods, in the time zone of the store.
+    hours      jsonb  NOT NULL DEFAULT '[]',
+    CONSTRAINT store_branches_latitude_check CHECK (latitude BETWEEN -90 AND 90),
+    CONSTRAINT store_branches_longitude_check CHECK (longitude BETWEEN -180 AND 180),
+    CONSTRAINT store_branches_location_check CHECK ((latitude IS NULL) = (longitude IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS store_branches_store_id_idx ON store_branches (store_id);
+
+-- stores.number_of_branches used to be typed in by clients. It's now the number of branch rows,
+-- kept up to date by the trigger below, so the store filters and sorting can keep using the
+-- column. The old counts had nothing behind them and start over from zero: every store shows 0
+-- branches until its branches are added. They are kept in legacy_number_of_branches, which the
+-- API doesn't read, so they can be looked up or put back by the down migration.
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS legacy_number_of_branches int;
+UPDATE stores SET legacy_number_of_branches = number_of_branches, number_of_branches = 0;
+
+CREATE OR REPLACE FUNCTION store_branches_count() RETURNS trigger AS
+$$
+BEGIN
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE stores SET number_of_branches = number_of_branches + 1 WHERE id = NEW.store_id;
+    END IF;
+    IF TG_OP IN ('DELETE', 'UPDATE') THEN
+        UPDATE stores SET number_of_branches = number_of_branches - 1 WHERE id = OLD.store_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER store_branches_count
+    AFTER INSERT OR DELETE OR UPDATE OF store_id ON store_branches
+    FOR EACH ROW
+EXECUTE FUNCTION store_branches_count();
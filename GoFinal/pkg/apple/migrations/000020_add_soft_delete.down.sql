@@ -0,0 +1,10 @@
+DELETE FROM permissions WHERE code = 'trash:read';
+
+-- Without the column the rows in the trash would come back, so they are deleted for good.
+DELETE FROM products WHERE deleted_at IS NOT NULL;
+DELETE FROM stores WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS products_deleted_at_idx;
+DROP INDEX IF EXISTS stores_deleted_at_idx;
+ALTER TABLE products DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE stores DROP COLUMN IF EXISTS deleted_at;
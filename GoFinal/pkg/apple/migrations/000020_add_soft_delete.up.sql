@@ -0,0 +1,10 @@
+-- Deleted products and stores are kept in the trash until they are restored or purged.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+-- The trash and the purge only ever look at the deleted rows.
+CREATE INDEX IF NOT EXISTS products_deleted_at_idx ON products (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS stores_deleted_at_idx ON stores (deleted_at) WHERE deleted_at IS NOT NULL;
+
+INSERT INTO permissions (code)
+VALUES ('trash:read');
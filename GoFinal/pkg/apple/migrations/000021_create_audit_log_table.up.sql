@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id          bigserial PRIMARY KEY,
+    created_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    -- The user who made the change, null for anonymous requests such as a registration.
+    actor_id    bigint REFERENCES users ON DELETE SET NULL,
+    action      text   NOT NULL,
+    entity_type text   NOT NULL,
+    -- Null when the change is not about a single entity, e.g. a bulk import.
+    entity_id   bigint,
+    -- The changed fields with their old and new values.
+    changes     jsonb  NOT NULL DEFAULT '{}',
+    request_id  text   NOT NULL DEFAULT '',
+    ip          inet
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx ON audit_log (actor_id);
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+
+INSERT INTO permissions (code)
+VALUES ('audit:read');
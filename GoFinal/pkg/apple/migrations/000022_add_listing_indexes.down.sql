@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS products_lower_title_idx;
+DROP INDEX IF EXISTS products_price_idx;
+DROP INDEX IF EXISTS stores_lower_title_idx;
+DROP INDEX IF EXISTS stores_number_of_branches_idx;
@@ -0,0 +1,19 @@
+DROP TRIGGER IF EXISTS product_images_version ON product_images;
+DROP TRIGGER IF EXISTS product_variants_version ON product_variants;
+DROP FUNCTION IF EXISTS product_parts_version();
+
+CREATE OR REPLACE FUNCTION store_branches_count() RETURNS trigger AS
+$$
+BEGIN
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE stores SET number_of_branches = number_of_branches + 1 WHERE id = NEW.store_id;
+    END IF;
+    IF TG_OP IN ('DELETE', 'UPDATE') THEN
+        UPDATE stores SET number_of_branches = number_of_branches - 1 WHERE id = OLD.store_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE stores DROP COLUMN IF EXISTS version;
+ALTER TABLE products DROP COLUMN IF EXISTS version;
@@ -0,0 +1,44 @@
+-- version goes up with every change of a product or store. It's sent as the ETag and checked
+-- against If-Match, which updated_at can't be trusted for: two changes within the same second
+-- get the same timestamp.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+ALTER TABLE stores ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+
+-- The number of branches is part of a store, so changing it makes a new version.
+CREATE OR REPLACE FUNCTION store_branches_count() RETURNS trigger AS
+$$
+BEGIN
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE stores SET number_of_branches = number_of_branches + 1, version = version + 1 WHERE id = NEW.store_id;
+    END IF;
+    IF TG_OP IN ('DELETE', 'UPDATE') THEN
+        UPDATE stores SET number_of_branches = number_of_branches - 1, version = version + 1 WHERE id = OLD.store_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- The variants and images of a product are sent along with it, so changing them makes a new
+-- version of the product too.
+CREATE OR REPLACE FUNCTION product_parts_version() RETURNS trigger AS
+$$
+BEGIN
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE products SET version = version + 1 WHERE id = NEW.product_id;
+    END IF;
+    IF TG_OP = 'DELETE' OR (TG_OP = 'UPDATE' AND OLD.product_id <> NEW.product_id) THEN
+        UPDATE products SET version = version + 1 WHERE id = OLD.product_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER product_variants_version
+    AFTER INSERT OR UPDATE OR DELETE ON product_variants
+    FOR EACH ROW
+EXECUTE FUNCTION product_parts_version();
+
+CREATE TRIGGER product_images_version
+    AFTER INSERT OR UPDATE OR DELETE ON product_images
+    FOR EACH ROW
+EXECUTE FUNCTION product_parts_version();
@@ -0,0 +1,243 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// The actions recorded in the audit log.
+const (
+	AuditCreate   = "create"
+	AuditUpdate   = "update"
+	AuditDelete   = "delete"
+	AuditRestore  = "restore"
+	AuditImport   = "import"
+	AuditActivate = "activate"
+	AuditGrant    = "grant"
+)
+
+// The types of the entities recorded in the audit log.
+const (
+	AuditProduct    = "product"
+	AuditStore      = "store"
+	AuditUser       = "user"
+	AuditPermission = "permission"
+)
+
+// auditIgnoredFields are left out of the changes, they change with every update.
+var auditIgnoredFields = []string{"updatedAt"}
+
+// AuditChange is the old and the new value of a changed field. From is null for a created entity,
+// To for a deleted one.
+type AuditChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// AuditEntry is a change made to an entity, who made it and from where.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	// ActorID is the user who made the change, null for an anonymous request.
+	ActorID    *int64 `json:"actorId"`
+	Action     string `json:"action"`
+	EntityType string `json:"entityType"`
+	// EntityID is null when the change is not about a single entity.
+	EntityID  *int64                 `json:"entityId"`
+	Changes   map[string]AuditChange `json:"changes"`
+	RequestID string                 `json:"requestId"`
+	IP        string                 `json:"ip"`
+	// Before is the entity before the change, nil when it didn't exist. Changes is made from it
+	// when the entry is recorded.
+	Before interface{} `json:"-"`
+}
+
+// AuditQuery holds the filters of the audit log. Zero values don't filter.
+type AuditQuery struct {
+	ActorID    int64
+	Action     string
+	EntityType string
+	EntityID   int64
+	From       time.Time
+	To         time.Time
+}
+
+// AuditDiff returns the fields whose JSON values differ between before and after, with the old
+// and the new value of each. before is nil for a created entity and after for a deleted one.
+func AuditDiff(before, after interface{}) (map[string]AuditChange, error) {
+	old, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]AuditChange)
+	for key, value := range updated {
+		if !reflect.DeepEqual(old[key], value) {
+			changes[key] = AuditChange{From: old[key], To: value}
+		}
+	}
+	for key, value := range old {
+		if _, ok := updated[key]; !ok {
+			changes[key] = AuditChange{From: value}
+		}
+	}
+
+	for _, key := range auditIgnoredFields {
+		delete(changes, key)
+	}
+
+	return changes, nil
+}
+
+// auditFields returns the fields of the JSON representation of entity.
+func auditFields(entity interface{}) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+
+	if entity == nil || (reflect.ValueOf(entity).Kind() == reflect.Ptr && reflect.ValueOf(entity).IsNil()) {
+		return fields, nil
+	}
+
+	js, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(js, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+type AuditModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// record adds the entry to the audit log within the transaction of the change it is about, so
+// the change is not made without it. after is the entity after the change, nil when it no longer
+// exists, and entityID is empty when the change is not about a single entity. A nil entry records
+// nothing.
+func (e *AuditEntry) record(ctx context.Context, tx *sql.Tx, entityID string, after interface{}) error {
+	if e == nil {
+		return nil
+	}
+
+	changes, err := AuditDiff(e.Before, after)
+	if err != nil {
+		return err
+	}
+	e.Changes = changes
+
+	if entityID != "" {
+		id, err := strconv.ParseInt(entityID, 10, 64)
+		if err != nil {
+			return err
+		}
+		e.EntityID = &id
+	}
+
+	js, err := json.Marshal(e.Changes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO audit_log (actor_id, action, entity_type, entity_id, changes, request_id, ip)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')::inet)
+		RETURNING id, created_at
+		`
+	args := []interface{}{e.ActorID, e.Action, e.EntityType, e.EntityID, js, e.RequestID, e.IP}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&e.ID, &e.CreatedAt)
+}
+
+// GetAll returns the entries of the audit log matching the filters.
+func (m AuditModel) GetAll(q AuditQuery, filters Filters) ([]*AuditEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, actor_id, action, entity_type, entity_id, changes, request_id, coalesce(host(ip), '')
+		FROM audit_log
+		WHERE (actor_id = $1 OR $1 = 0)
+			AND (action = $2 OR $2 = '')
+			AND (entity_type = $3 OR $3 = '')
+			AND (entity_id = $4 OR $4 = 0)
+			AND (created_at >= $5 OR $5 IS NULL)
+			AND (created_at < $6 OR $6 IS NULL)
+		ORDER BY %s, id DESC
+		LIMIT $7 OFFSET $8
+		`, filters.orderBy())
+
+	var from, to *time.Time
+	if !q.From.IsZero() {
+		from = &q.From
+	}
+	if !q.To.IsZero() {
+		to = &q.To
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, q.ActorID, q.Action, q.EntityType, q.EntityID, from, to, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var changes []byte
+		err := rows.Scan(&totalRecords, &entry.ID, &entry.CreatedAt, &entry.ActorID, &entry.Action, &entry.EntityType, &entry.EntityID, &changes, &entry.RequestID, &entry.IP)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// ValidateAuditQuery runs validation checks on the audit log filters.
+func ValidateAuditQuery(v *validator.Validator, q AuditQuery) {
+	v.Check(q.ActorID >= 0, "actor", "must be a user ID")
+	v.Check(q.EntityID >= 0, "entity_id", "must be an ID")
+	if q.Action != "" {
+		v.Check(validator.In(q.Action, AuditCreate, AuditUpdate, AuditDelete, AuditRestore, AuditImport, AuditActivate, AuditGrant), "action", "must be a known action")
+	}
+	if q.EntityType != "" {
+		v.Check(validator.In(q.EntityType, AuditProduct, AuditStore, AuditUser, AuditPermission), "entity_type", "must be product, store, user or permission")
+	}
+	if !q.From.IsZero() && !q.To.IsZero() {
+		v.Check(q.From.Before(q.To), "from", "must be before to")
+	}
+}
@@ -0,0 +1,283 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+var (
+	// ErrDuplicateSlug is returned when another category already has the slug.
+	ErrDuplicateSlug = errors.New("duplicate slug")
+
+	// ErrCategoryCycle is returned when a category would become its own ancestor.
+	ErrCategoryCycle = errors.New("category can't be moved below itself")
+
+	// ErrCategoryHasChildren is returned when a category that still has subcategories is deleted.
+	ErrCategoryHasChildren = errors.New("category has subcategories")
+)
+
+// Category is a node of the product category tree, e.g. "iPhone" below "Phones".
+type Category struct {
+	ID        int64       `json:"id"`
+	CreatedAt time.Time   `json:"createdAt"`
+	UpdatedAt time.Time   `json:"updatedAt"`
+	ParentID  *int64      `json:"parentId"`
+	Title     string      `json:"title"`
+	Slug      string      `json:"slug"`
+	Children  []*Category `json:"children,omitempty"`
+}
+
+type CategoryModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// categorySubtreeSQL returns a subquery selecting the ID of the category in the given placeholder
+// and the IDs of all of its descendants.
+func categorySubtreeSQL(placeholder string) string {
+	return fmt.Sprintf(`
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM categories WHERE id = %s
+			UNION ALL
+			SELECT c.id FROM categories c INNER JOIN subtree ON c.parent_id = subtree.id
+		)
+		SELECT id FROM subtree`, placeholder)
+}
+
+// GetTree returns every category arranged as a tree: the top level categories with their
+// descendants in Children, ordered by title on every level.
+func (m CategoryModel) GetTree() ([]*Category, error) {
+	query := `
+		SELECT id, created_at, updated_at, parent_id, title, slug
+		FROM categories
+		ORDER BY title ASC, id ASC
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var all []*Category
+	byID := map[int64]*Category{}
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt, &c.ParentID, &c.Title, &c.Slug); err != nil {
+			return nil, err
+		}
+
+		all = append(all, &c)
+		byID[c.ID] = &c
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	roots := []*Category{}
+	for _, c := range all {
+		if c.ParentID == nil {
+			roots = append(roots, c)
+			continue
+		}
+
+		parent := byID[*c.ParentID]
+		parent.Children = append(parent.Children, c)
+	}
+
+	return roots, nil
+}
+
+// Get returns the category with its direct children.
+func (m CategoryModel) Get(id int64) (*Category, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, updated_at, parent_id, title, slug
+		FROM categories
+		WHERE id = $1 OR parent_id = $1
+		ORDER BY parent_id NULLS FIRST, title ASC, id ASC
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	var category *Category
+	var children []*Category
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt, &c.ParentID, &c.Title, &c.Slug); err != nil {
+			return nil, err
+		}
+
+		if c.ID == id {
+			category = &c
+		} else {
+			children = append(children, &c)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if category == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	category.Children = children
+	return category, nil
+}
+
+// Insert adds a category. It returns ErrRecordNotFound if the parent doesn't exist.
+func (m CategoryModel) Insert(category *Category) error {
+	query := `
+		INSERT INTO categories (parent_id, title, slug)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, updated_at
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, category.ParentID, category.Title, category.Slug).Scan(&category.ID, &category.CreatedAt, &category.UpdatedAt)
+	return categoryError(err)
+}
+
+// Update saves the title, slug and parent of the category. Moving a category below itself or
+// one of its descendants returns ErrCategoryCycle.
+func (m CategoryModel) Update(category *Category) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		if category.ParentID != nil {
+			// Two concurrent moves could each be fine on their own and still form a cycle
+			// together, so moves are serialized. Categories change rarely.
+			_, err := tx.ExecContext(ctx, `LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE`)
+			if err != nil {
+				return err
+			}
+
+			var cycle bool
+			query := fmt.Sprintf(`SELECT $1 IN (%s)`, categorySubtreeSQL("$2"))
+			if err := tx.QueryRowContext(ctx, query, *category.ParentID, category.ID).Scan(&cycle); err != nil {
+				return err
+			}
+			if cycle {
+				return ErrCategoryCycle
+			}
+		}
+
+		query := `
+			UPDATE categories
+			SET parent_id = $1, title = $2, slug = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $4
+			RETURNING updated_at
+			`
+
+		err := tx.QueryRowContext(ctx, query, category.ParentID, category.Title, category.Slug, category.ID).Scan(&category.UpdatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+
+		return categoryError(err)
+	})
+}
+
+// Delete removes a category that has no subcategories. Its products are left without a category.
+func (m CategoryModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM categories
+		WHERE id = $1
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return categoryError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// categoryError translates the constraint violations of the categories table into the errors of
+// this package.
+func categoryError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch err.Error() {
+	case `pq: duplicate key value violates unique constraint "categories_slug_key"`:
+		return ErrDuplicateSlug
+	case `pq: insert or update on table "categories" violates foreign key constraint "categories_parent_id_fkey"`:
+		return ErrRecordNotFound
+	case `pq: update or delete on table "categories" violates foreign key constraint "categories_parent_id_fkey" on table "categories"`:
+		return ErrCategoryHasChildren
+	case `pq: new row for relation "categories" violates check constraint "categories_parent_check"`:
+		return ErrCategoryCycle
+	default:
+		return err
+	}
+}
+
+// slugRX matches lowercase words separated by single hyphens, such as "iphone" or "apple-watch".
+var slugRX = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+func ValidateCategory(v *validator.Validator, category *Category) {
+	v.Check(category.Title != "", "title", "must be provided")
+	v.Check(len(category.Title) <= 100, "title", "must not be more than 100 bytes long")
+
+	v.Check(category.Slug != "", "slug", "must be provided")
+	v.Check(len(category.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(category.Slug == "" || validator.Matches(category.Slug, slugRX), "slug", "must only contain lowercase letters, digits and single hyphens")
+
+	if category.ParentID != nil {
+		v.Check(*category.ParentID > 0, "parentId", "must be a category ID")
+		v.Check(*category.ParentID != category.ID, "parentId", "must not be the category itself")
+	}
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+// pageMetadata returns the metadata of a page of n records of a listing, counted the way the
+// filters ask for. total is the exact count the page query made, if it made one. An estimate is
+// made for the records of from (a table and its alias) matching the where condition and its args.
+func pageMetadata(ctx context.Context, db *sql.DB, from, where string, args []interface{}, f Filters, total, n int) (Metadata, error) {
+	switch f.Count {
+	case CountNone:
+		if n == 0 {
+			return Metadata{}, nil
+		}
+		return Metadata{CurrentPage: f.Page, PageSize: f.PageSize, FirstPage: 1}, nil
+
+	case CountEstimated:
+		if n == 0 {
+			return Metadata{}, nil
+		}
+
+		// A page that isn't full is the last one, which gives the exact total for free.
+		seen := f.offset() + n
+		if n < f.PageSize {
+			return calculateMetadata(seen, f.Page, f.PageSize), nil
+		}
+
+		estimate, err := estimateCount(ctx, db, from, where, args)
+		if err != nil {
+			return Metadata{}, err
+		}
+
+		// The estimate can be off either way, but there are at least as many records as the pages
+		// up to this one hold.
+		metadata := calculateMetadata(max(estimate, seen), f.Page, f.PageSize)
+		metadata.TotalEstimated = true
+		return metadata, nil
+	}
+
+	return calculateMetadata(total, f.Page, f.PageSize), nil
+}
+
+// estimateCount returns the query planner's estimate of the number of records of from matching
+// the where condition. Postgres bases it on the table statistics kept up to date by autovacuum
+// (pg_class.reltuples and the column statistics), so it costs no more than planning the query
+// however many records match.
+func estimateCount(ctx context.Context, db *sql.DB, from, where string, args []interface{}) (int, error) {
+	var plan []byte
+	err := db.QueryRowContext(ctx, `EXPLAIN (FORMAT JSON) SELECT 1 FROM `+from+` WHERE `+where, args...).Scan(&plan)
+	if err != nil {
+		return 0, err
+	}
+
+	var explained []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(plan, &explained); err != nil {
+		return 0, err
+	}
+
+	if len(explained) == 0 {
+		return 0, errors.New("empty query plan")
+	}
+
+	return int(math.Round(explained[0].Plan.Rows)), nil
+}
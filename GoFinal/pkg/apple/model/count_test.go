@@ -0,0 +1,134 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// benchSeedDescription marks the products seeded by the benchmarks.
+const benchSeedDescription = "Seeded by BenchmarkProductGetAll"
+
+// benchSeedBatch is the number of products seeded by one statement.
+const benchSeedBatch = 100_000
+
+// BenchmarkProductGetAll compares the count strategies of the product list on a large table. The
+// products table of the APPLE_TEST_DSN database is seeded up to APPLE_BENCH_PRODUCTS products, a
+// million by default, which are deleted again when the benchmark is done:
+//
+//	APPLE_TEST_DSN=postgres://... go test ./pkg/apple/model -run '^$' -bench ProductGetAll -benchtime 5x
+//
+// The "total" metric is the total_records of the page. A listing that runs into the timeout of
+// GetAll is skipped and says so.
+func BenchmarkProductGetAll(b *testing.B) {
+	db := testDB(b)
+	models := NewModels(db)
+
+	products := 1_000_000
+	if s := os.Getenv("APPLE_BENCH_PRODUCTS"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			b.Fatalf("APPLE_BENCH_PRODUCTS: %v", err)
+		}
+		products = n
+	}
+
+	b.Cleanup(func() {
+		mustExec(b, db, `DELETE FROM products WHERE description = $1`, benchSeedDescription)
+		mustExec(b, db, `ANALYZE products`)
+	})
+
+	if err := seedBenchProducts(db, products); err != nil {
+		b.Fatal(err)
+	}
+
+	listings := []struct {
+		name  string
+		query ProductQuery
+		page  int
+		sort  string
+	}{
+		{name: "all", page: 1, sort: "id"},
+		{name: "all_page_2000", page: 2000, sort: "id"},
+		{name: "title", query: ProductQuery{Title: "Bench product 42"}, page: 1, sort: "id"},
+		{name: "price_range", query: ProductQuery{PriceFrom: 100000, PriceTo: 150000, PriceCurrency: "USD"}, page: 1, sort: "price"},
+		{name: "country", query: ProductQuery{Country: "JP", PriceCurrency: "USD"}, page: 1, sort: "-price"},
+		{name: "search", query: ProductQuery{Query: "bench"}, page: 1, sort: SortRelevance},
+	}
+
+	for _, l := range listings {
+		b.Run(l.name, func(b *testing.B) {
+			for _, count := range []string{CountExact, CountEstimated, CountNone} {
+				b.Run(count, func(b *testing.B) {
+					filters := Filters{
+						Page:         l.page,
+						PageSize:     20,
+						Sort:         l.sort,
+						SortSafeList: []string{l.sort},
+						Count:        count,
+					}
+
+					var metadata Metadata
+					for i := 0; i < b.N; i++ {
+						var err error
+						_, metadata, err = models.Products.GetAll(l.query, filters)
+						if timedOut(err) {
+							b.Skipf("timed out: %v", err)
+						}
+						if err != nil {
+							b.Fatal(err)
+						}
+					}
+
+					b.ReportMetric(float64(metadata.TotalRecords), "total")
+				})
+			}
+		})
+	}
+}
+
+// timedOut tells whether a query was cancelled by its context running out. Depending on when it
+// happens that is reported by the driver or by Postgres.
+func timedOut(err error) bool {
+	var pqErr *pq.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &pqErr) && pqErr.Code == "57014")
+}
+
+// seedBenchProducts inserts products until there are at least the wanted number of them, and
+// refreshes the statistics the estimates are made from.
+func seedBenchProducts(db *sql.DB, products int) error {
+	var existing int
+	err := db.QueryRow(`SELECT count(*) FROM products WHERE deleted_at IS NULL`).Scan(&existing)
+	if err != nil {
+		return err
+	}
+
+	if existing >= products {
+		return nil
+	}
+
+	// The titles repeat every 5000 products and the prices spread over 0 to 5000.00 USD, so the
+	// title and price filters match a small part of the table and the country filter a fifth.
+	query := `
+		INSERT INTO products (title, description, countries, price, currency)
+		SELECT 'Bench product ' || (n % 5000), $3,
+			ARRAY[(ARRAY['US', 'JP', 'DE', 'FR', 'CH'])[1 + n % 5]],
+			(n::bigint * 7919) % 500000, 'USD'
+		FROM generate_series($1::int, $2::int) AS n
+		`
+
+	for from := existing + 1; from <= products; from += benchSeedBatch {
+		to := min(from+benchSeedBatch-1, products)
+		if _, err := db.Exec(query, from, to, benchSeedDescription); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec(`ANALYZE products`)
+	return err
+}
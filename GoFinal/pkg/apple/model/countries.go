@@ -0,0 +1,136 @@
+package model
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// countryCodes holds the ISO 3166-1 alpha-2 country codes.
+var countryCodes = map[string]bool{}
+
+func init() {
+	for _, code := range []string{
+		"AD", "AE", "AF", "AG", "AI", "AL", "AM", "AO", "AQ", "AR", "AS", "AT", "AU", "AW", "AX", "AZ",
+		"BA", "BB", "BD", "BE", "BF", "BG", "BH", "BI", "BJ", "BL", "BM", "BN", "BO", "BQ", "BR", "BS",
+		"BT", "BV", "BW", "BY", "BZ", "CA", "CC", "CD", "CF", "CG", "CH", "CI", "CK", "CL", "CM", "CN",
+		"CO", "CR", "CU", "CV", "CW", "CX", "CY", "CZ", "DE", "DJ", "DK", "DM", "DO", "DZ", "EC", "EE",
+		"EG", "EH", "ER", "ES", "ET", "FI", "FJ", "FK", "FM", "FO", "FR", "GA", "GB", "GD", "GE", "GF",
+		"GG", "GH", "GI", "GL", "GM", "GN", "GP", "GQ", "GR", "GS", "GT", "GU", "GW", "GY", "HK", "HM",
+		"HN", "HR", "HT", "HU", "ID", "IE", "IL", "IM", "IN", "IO", "IQ", "IR", "IS", "IT", "JE", "JM",
+		"JO", "JP", "KE", "KG", "KH", "KI", "KM", "KN", "KP", "KR", "KW", "KY", "KZ", "LA", "LB", "LC",
+		"LI", "LK", "LR", "LS", "LT", "LU", "LV", "LY", "MA", "MC", "MD", "ME", "MF", "MG", "MH", "MK",
+		"ML", "MM", "MN", "MO", "MP", "MQ", "MR", "MS", "MT", "MU", "MV", "MW", "MX", "MY", "MZ", "NA",
+		"NC", "NE", "NF", "NG", "NI", "NL", "NO", "NP", "NR", "NU", "NZ", "OM", "PA", "PE", "PF", "PG",
+		"PH", "PK", "PL", "PM", "PN", "PR", "PS", "PT", "PW", "PY", "QA", "RE", "RO", "RS", "RU", "RW",
+		"SA", "SB", "SC", "SD", "SE", "SG", "SH", "SI", "SJ", "SK", "SL", "SM", "SN", "SO", "SR", "SS",
+		"ST", "SV", "SX", "SY", "SZ", "TC", "TD", "TF", "TG", "TH", "TJ", "TK", "TL", "TM", "TN", "TO",
+		"TR", "TT", "TV", "TW", "TZ", "UA", "UG", "UM", "US", "UY", "UZ", "VA", "VC", "VE", "VG", "VI",
+		"VN", "VU", "WF", "WS", "YE", "YT", "ZA", "ZM", "ZW",
+	} {
+		countryCodes[code] = true
+	}
+}
+
+// euMembers holds the member states of the European Union.
+var euMembers = []string{
+	"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR", "HR", "HU",
+	"IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL", "PT", "RO", "SE", "SI", "SK",
+}
+
+// regions maps the named regions a product can be made for to the countries they expand to.
+var regions = map[string][]string{
+	"EU": euMembers,
+	// The European Economic Area is the EU plus Iceland, Liechtenstein and Norway.
+	"EEA": append(append([]string{}, euMembers...), "IS", "LI", "NO"),
+}
+
+// IsCountry reports whether code is an ISO 3166-1 alpha-2 country code.
+func IsCountry(code string) bool {
+	return countryCodes[code]
+}
+
+// IsRegion reports whether code is a named region such as "EU".
+func IsRegion(code string) bool {
+	_, ok := regions[code]
+	return ok
+}
+
+// NormalizeCountries upper-cases and trims the codes and drops the empty ones.
+func NormalizeCountries(codes []string) []string {
+	normalized := make([]string, 0, len(codes))
+	for _, code := range codes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code != "" {
+			normalized = append(normalized, code)
+		}
+	}
+
+	return normalized
+}
+
+// expandCountry returns the countries a country code or region stands for.
+func expandCountry(code string) []string {
+	if members, ok := regions[code]; ok {
+		return members
+	}
+
+	return []string{code}
+}
+
+// coveringCodes returns every code a product may be stored with to be available in the given
+// country or region: the countries it expands to and every region containing any of them. So
+// "DE" is covered by "DE", "EU" and "EEA", and "EU" by all of its members, "EU" and "EEA".
+func coveringCodes(code string) []string {
+	wanted := make(map[string]bool)
+	for _, country := range expandCountry(code) {
+		wanted[country] = true
+	}
+
+	covering := make(map[string]bool, len(wanted))
+	for country := range wanted {
+		covering[country] = true
+	}
+	for region, members := range regions {
+		for _, member := range members {
+			if wanted[member] {
+				covering[region] = true
+				break
+			}
+		}
+	}
+
+	codes := make([]string, 0, len(covering))
+	for c := range covering {
+		codes = append(codes, c)
+	}
+	sort.Strings(codes)
+
+	return codes
+}
+
+// ValidateCountries checks that codes is a non-empty list of unique ISO 3166-1 alpha-2 codes and
+// named regions.
+func ValidateCountries(v *validator.Validator, key string, codes []string) {
+	v.Check(len(codes) > 0, key, "must contain at least 1 country")
+	v.Check(len(codes) <= 300, key, "must not contain more than 300 countries")
+	v.Check(validator.Unique(codes), key, "must not contain duplicate values")
+
+	for _, code := range codes {
+		if !IsCountry(code) && !IsRegion(code) {
+			v.AddError(key, "must only contain ISO 3166-1 alpha-2 country codes or regions ("+strings.Join(regionNames(), ", ")+"), got "+code)
+			break
+		}
+	}
+}
+
+func regionNames() []string {
+	names := make([]string, 0, len(regions))
+	for name := range regions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,58 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/lib/pq"
+)
+
+// testDB connects to the scratch database in APPLE_TEST_DSN and brings it up to the latest
+// migration. Tests that need a database are skipped when it isn't set. The tests add their own
+// records and remove them again, but the database should not hold anything worth keeping.
+func testDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+
+	dsn := os.Getenv("APPLE_TEST_DSN")
+	if dsn == "" {
+		tb.Skip("APPLE_TEST_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	if err := db.Ping(); err != nil {
+		tb.Fatal(err)
+	}
+
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	m, err := migrate.NewWithDatabaseInstance("file://../migrations", "postgres", driver)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		tb.Fatal(err)
+	}
+
+	return db
+}
+
+// mustExec runs a statement the test can't go on without.
+func mustExec(tb testing.TB, db *sql.DB, query string, args ...interface{}) {
+	tb.Helper()
+
+	if _, err := db.Exec(query, args...); err != nil {
+		tb.Fatal(err)
+	}
+}
@@ -0,0 +1,143 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math/big"
+	"regexp"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// ExchangeRate is the number of units of Currency one unit of the BaseCurrency buys. Rate is kept
+// as a decimal string, so no precision is lost on the way to and from the database.
+type ExchangeRate struct {
+	Currency  string    `json:"currency"`
+	Rate      string    `json:"rate"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type ExchangeRateModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// GetAll returns every exchange rate ordered by currency.
+func (m ExchangeRateModel) GetAll() ([]*ExchangeRate, error) {
+	query := `
+		SELECT currency, rate::text, updated_at
+		FROM exchange_rates
+		ORDER BY currency
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	rates := []*ExchangeRate{}
+	for rows.Next() {
+		var rate ExchangeRate
+		if err := rows.Scan(&rate.Currency, &rate.Rate, &rate.UpdatedAt); err != nil {
+			return nil, err
+		}
+
+		rates = append(rates, &rate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+// Rates returns every exchange rate in the form Money.Convert works with.
+func (m ExchangeRateModel) Rates() (ExchangeRates, error) {
+	all, err := m.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	rates := make(ExchangeRates, len(all))
+	for _, rate := range all {
+		r, ok := new(big.Rat).SetString(rate.Rate)
+		if !ok {
+			m.ErrorLog.Printf("invalid exchange rate %q for %s", rate.Rate, rate.Currency)
+			continue
+		}
+
+		rates[rate.Currency] = r
+	}
+
+	return rates, nil
+}
+
+// Upsert sets the exchange rate of a currency.
+func (m ExchangeRateModel) Upsert(rate *ExchangeRate) error {
+	query := `
+		INSERT INTO exchange_rates (currency, rate)
+		VALUES ($1, $2)
+		ON CONFLICT (currency) DO UPDATE
+			SET rate = EXCLUDED.rate, updated_at = CURRENT_TIMESTAMP
+		RETURNING rate::text, updated_at
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, rate.Currency, rate.Rate).Scan(&rate.Rate, &rate.UpdatedAt)
+}
+
+// Delete removes the exchange rate of a currency.
+func (m ExchangeRateModel) Delete(currency string) error {
+	query := `
+		DELETE FROM exchange_rates
+		WHERE currency = $1
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, currency)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// decimalRX matches a plain decimal number that fits the numeric(20, 10) column, such as "0.92"
+// or "157".
+var decimalRX = regexp.MustCompile(`^[0-9]{1,10}(\.[0-9]{1,10})?$`)
+
+func ValidateExchangeRate(v *validator.Validator, rate *ExchangeRate) {
+	v.Check(IsCurrency(rate.Currency), "currency", "must be a supported ISO 4217 currency")
+	v.Check(rate.Currency != BaseCurrency, "currency", "must not be the base currency "+BaseCurrency)
+
+	v.Check(validator.Matches(rate.Rate, decimalRX), "rate", "must be a decimal number with at most 10 digits before and after the point")
+
+	if r, ok := new(big.Rat).SetString(rate.Rate); ok {
+		v.Check(r.Sign() > 0, "rate", "must be greater than 0")
+	}
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// exportTxOptions makes an export read everything from a single snapshot of the database, so it's
+// consistent even while the records are being changed.
+var exportTxOptions = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+
+// Export calls fn with every product matching the filters, in the order of the sort. The products
+// are streamed from the database one by one instead of being collected, so any number of them can
+// be exported. The export stops at the first error returned by fn. It runs until ctx is done.
+func (p ProductModel) Export(ctx context.Context, q ProductQuery, filters Filters, fn func(*Products) error) error {
+	where, args := q.where()
+
+	query := fmt.Sprintf(
+		`
+		SELECT id, created_at, updated_at, title, coalesce(description, ''), countries, price, currency, category_id,
+			CASE WHEN $6 = '' THEN 0 ELSE ts_rank(search, websearch_to_tsquery('%[2]s', $6)) END AS search_rank
+		FROM products
+		WHERE %[3]s
+		ORDER BY %[1]s, id ASC
+		`,
+		filters.orderBy(), searchConfig, where)
+
+	return withTx(ctx, p.DB, exportTxOptions, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := rows.Close(); err != nil {
+				p.ErrorLog.Println(err)
+			}
+		}()
+
+		for rows.Next() {
+			var prod Products
+			var rank float64
+			err := rows.Scan(&prod.Id, &prod.CreatedAt, &prod.UpdatedAt, &prod.Title, &prod.Description, pq.Array(&prod.Countries), &prod.Price.Amount, &prod.Price.Currency, &prod.CategoryID,
+				&rank)
+			if err != nil {
+				return err
+			}
+
+			if err := fn(&prod); err != nil {
+				return err
+			}
+		}
+
+		return rows.Err()
+	})
+}
+
+// Export calls fn with every store matching the filters, in the order of the sort, the same way
+// ProductModel.Export does.
+func (s StoreModel) Export(ctx context.Context, q StoreQuery, filters Filters, fn func(*Store) error) error {
+	where, args := q.where()
+
+	query := fmt.Sprintf(
+		`
+		SELECT id, created_at, updated_at, title, description, address, latitude, longitude, number_of_branches, time_zone,
+			CASE WHEN $4 = '' THEN 0 ELSE ts_rank(search, websearch_to_tsquery('%[2]s', $4)) END AS search_rank
+		FROM stores s
+		WHERE %[3]s
+		ORDER BY %[1]s, id ASC
+		`,
+		filters.orderBy(), searchConfig, where)
+
+	return withTx(ctx, s.DB, exportTxOptions, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := rows.Close(); err != nil {
+				s.ErrorLog.Println(err)
+			}
+		}()
+
+		for rows.Next() {
+			var store Store
+			var rank float64
+			err := rows.Scan(&store.Id, &store.CreatedAt, &store.UpdatedAt, &store.Title, &store.Description, &store.Address, &store.Latitude, &store.Longitude, &store.NumberOfBranches, &store.TimeZone,
+				&rank)
+			if err != nil {
+				return err
+			}
+
+			if err := fn(&store); err != nil {
+				return err
+			}
+		}
+
+		return rows.Err()
+	})
+}
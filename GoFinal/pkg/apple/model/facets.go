@@ -0,0 +1,229 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+	"github.com/lib/pq"
+)
+
+// The facets the product list can be counted by.
+const (
+	FacetCountry  = "country"
+	FacetPrice    = "price"
+	FacetCategory = "category"
+)
+
+// ProductFacets returns the names of the facets of the product list.
+func ProductFacets() []string {
+	return []string{FacetCountry, FacetPrice, FacetCategory}
+}
+
+// priceBuckets are the lower bounds of the price ranges in major units of the product currency.
+// The last range has no upper bound.
+var priceBuckets = []int64{0, 100, 250, 500, 1000, 2000}
+
+// FacetCount is the number of products having a facet value, e.g. the products made for "US".
+// Title is the display name of the value where it's not self-explanatory, like for categories.
+type FacetCount struct {
+	Value string `json:"value"`
+	Title string `json:"title,omitempty"`
+	Count int    `json:"count"`
+}
+
+// PriceRange is the number of products priced in [From, To). To is nil for the most expensive
+// range. The amounts are in minor units, like the priceFrom and priceTo filters.
+type PriceRange struct {
+	From  Money  `json:"from"`
+	To    *Money `json:"to"`
+	Count int    `json:"count"`
+}
+
+// Facets holds the counts of the requested facets. A facet that was not requested is nil.
+type Facets struct {
+	Country  []*FacetCount `json:"country,omitempty"`
+	Price    []*PriceRange `json:"price,omitempty"`
+	Category []*FacetCount `json:"category,omitempty"`
+}
+
+// Facets counts the products matching the query by each of the named facets.
+func (p ProductModel) Facets(q ProductQuery, names []string) (*Facets, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	facets := &Facets{}
+	for _, name := range names {
+		var err error
+		switch name {
+		case FacetCountry:
+			facets.Country, err = p.countryFacet(ctx, q)
+		case FacetPrice:
+			facets.Price, err = p.priceFacet(ctx, q)
+		case FacetCategory:
+			facets.Category, err = p.categoryFacet(ctx, q)
+		default:
+			err = fmt.Errorf("unknown facet %q", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return facets, nil
+}
+
+// countryFacet counts the products by the countries and regions they are made for. A product made
+// for several countries is counted for each of them.
+func (p ProductModel) countryFacet(ctx context.Context, q ProductQuery) ([]*FacetCount, error) {
+	where, args := q.where()
+
+	query := fmt.Sprintf(`
+		SELECT country, count(*)
+		FROM products, unnest(countries) AS country
+		WHERE %s
+		GROUP BY country
+		ORDER BY count(*) DESC, country ASC
+		`, where)
+
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			p.ErrorLog.Println(err)
+		}
+	}()
+
+	counts := []*FacetCount{}
+	for rows.Next() {
+		var c FacetCount
+		if err := rows.Scan(&c.Value, &c.Count); err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, &c)
+	}
+
+	return counts, rows.Err()
+}
+
+// categoryFacet counts the products by the category they are directly assigned to. Products
+// without a category are not counted.
+func (p ProductModel) categoryFacet(ctx context.Context, q ProductQuery) ([]*FacetCount, error) {
+	where, args := q.where()
+
+	query := fmt.Sprintf(`
+		SELECT c.id, c.title, f.count
+		FROM (
+			SELECT category_id, count(*) AS count
+			FROM products
+			WHERE %s
+			GROUP BY category_id
+		) AS f
+			INNER JOIN categories c ON c.id = f.category_id
+		ORDER BY f.count DESC, c.title ASC
+		`, where)
+
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			p.ErrorLog.Println(err)
+		}
+	}()
+
+	counts := []*FacetCount{}
+	for rows.Next() {
+		var c FacetCount
+		if err := rows.Scan(&c.Value, &c.Title, &c.Count); err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, &c)
+	}
+
+	return counts, rows.Err()
+}
+
+// priceFacet counts the products by price range. Prices are not converted, so every currency gets
+// its own ranges.
+func (p ProductModel) priceFacet(ctx context.Context, q ProductQuery) ([]*PriceRange, error) {
+	where, args := q.where()
+
+	// width_bucket returns the 1-based index of the range the price in major units falls into.
+	query := fmt.Sprintf(`
+		SELECT currency, width_bucket(price / %s, $%d::bigint[]) AS bucket, count(*)
+		FROM products
+		WHERE %s
+		GROUP BY currency, bucket
+		ORDER BY currency ASC, bucket ASC
+		`, minorUnitsSQL("currency"), len(args)+1, where)
+
+	args = append(args, pq.Array(priceBuckets))
+
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			p.ErrorLog.Println(err)
+		}
+	}()
+
+	ranges := []*PriceRange{}
+	for rows.Next() {
+		var currency string
+		var bucket int
+		var r PriceRange
+		if err := rows.Scan(&currency, &bucket, &r.Count); err != nil {
+			return nil, err
+		}
+
+		// Prices are never negative, so bucket 0 (below the first bound) can't happen.
+		if bucket < 1 {
+			continue
+		}
+
+		unit := pow10(currencyExponents[currency])
+		r.From = Money{Amount: priceBuckets[bucket-1] * unit, Currency: currency}
+		if bucket < len(priceBuckets) {
+			r.To = &Money{Amount: priceBuckets[bucket] * unit, Currency: currency}
+		}
+
+		ranges = append(ranges, &r)
+	}
+
+	return ranges, rows.Err()
+}
+
+// minorUnitsSQL returns an SQL expression for the number of minor units in one major unit of the
+// currency in the given column, e.g. 100 for USD and 1 for JPY.
+func minorUnitsSQL(column string) string {
+	codes := Currencies()
+	sort.Strings(codes)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CASE %s", column)
+	for _, code := range codes {
+		fmt.Fprintf(&b, " WHEN '%s' THEN %d", code, pow10(currencyExponents[code]))
+	}
+	b.WriteString(" ELSE 1 END")
+
+	return b.String()
+}
+
+// ValidateFacets checks that every requested facet is known and requested only once.
+func ValidateFacets(v *validator.Validator, names []string, safelist []string) {
+	for _, name := range names {
+		v.Check(validator.In(name, safelist...), "facets", "must only contain "+strings.Join(safelist, ", "))
+	}
+	v.Check(validator.Unique(names), "facets", "must not contain duplicate values")
+}
@@ -0,0 +1,131 @@
+package model
+
+import (
+	"strings"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+	"github.com/lib/pq"
+)
+
+// ValidateFields checks that every field a client asked for is in the safelist of the endpoint.
+func ValidateFields(v *validator.Validator, fields, safeList []string) {
+	for _, field := range fields {
+		v.Check(validator.In(field, safeList...), "fields", "invalid field value: "+field)
+	}
+	v.Check(validator.Unique(fields), "fields", "must not contain duplicate values")
+}
+
+// column is a column of a record and the field of the record it's scanned into.
+type column[T any] struct {
+	name string
+	dest func(*T) interface{}
+}
+
+// fieldColumns maps the fields of a record to the columns they are read from. columns holds every
+// column in the order they are selected, fields the columns each field needs. Fields worked out
+// by the handlers, such as the opening status of a store, need none.
+type fieldColumns[T any] struct {
+	columns []column[T]
+	fields  map[string][]string
+}
+
+// selected returns the columns the fields need, or every column when no fields are given. The
+// columns named by always are selected either way; they are what the query itself relies on,
+// such as the ID and the sort column.
+func (c fieldColumns[T]) selected(fields []string, always ...string) []column[T] {
+	if len(fields) == 0 {
+		return c.columns
+	}
+
+	needed := make(map[string]bool)
+	for _, name := range always {
+		needed[name] = true
+	}
+	for _, field := range fields {
+		for _, name := range c.fields[field] {
+			needed[name] = true
+		}
+	}
+
+	var columns []column[T]
+	for _, col := range c.columns {
+		if needed[col.name] {
+			columns = append(columns, col)
+		}
+	}
+
+	return columns
+}
+
+// selectList returns the names of the columns as the list of a SELECT.
+func selectList[T any](columns []column[T]) string {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// scanDest returns the fields of record the columns are scanned into.
+func scanDest[T any](columns []column[T], record *T) []interface{} {
+	dest := make([]interface{}, len(columns))
+	for i, col := range columns {
+		dest[i] = col.dest(record)
+	}
+	return dest
+}
+
+var productColumns = fieldColumns[Products]{
+	columns: []column[Products]{
+		{"id", func(p *Products) interface{} { return &p.Id }},
+		{"created_at", func(p *Products) interface{} { return &p.CreatedAt }},
+		{"updated_at", func(p *Products) interface{} { return &p.UpdatedAt }},
+		{"title", func(p *Products) interface{} { return &p.Title }},
+		{"description", func(p *Products) interface{} { return &p.Description }},
+		{"countries", func(p *Products) interface{} { return pq.Array(&p.Countries) }},
+		{"price", func(p *Products) interface{} { return &p.Price.Amount }},
+		{"currency", func(p *Products) interface{} { return &p.Price.Currency }},
+		{"category_id", func(p *Products) interface{} { return &p.CategoryID }},
+		{"version", func(p *Products) interface{} { return &p.Version }},
+	},
+	fields: map[string][]string{
+		"id":          {"id"},
+		"createdAt":   {"created_at"},
+		"updatedAt":   {"updated_at"},
+		"title":       {"title"},
+		"description": {"description"},
+		"countries":   {"countries"},
+		"price":       {"price", "currency"},
+		"categoryId":  {"category_id"},
+		// The converted price is worked out from the price.
+		"convertedPrice": {"price", "currency"},
+	},
+}
+
+var storeColumns = fieldColumns[Store]{
+	columns: []column[Store]{
+		{"id", func(s *Store) interface{} { return &s.Id }},
+		{"created_at", func(s *Store) interface{} { return &s.CreatedAt }},
+		{"updated_at", func(s *Store) interface{} { return &s.UpdatedAt }},
+		{"title", func(s *Store) interface{} { return &s.Title }},
+		{"description", func(s *Store) interface{} { return &s.Description }},
+		{"address", func(s *Store) interface{} { return &s.Address }},
+		{"latitude", func(s *Store) interface{} { return &s.Latitude }},
+		{"longitude", func(s *Store) interface{} { return &s.Longitude }},
+		{"number_of_branches", func(s *Store) interface{} { return &s.NumberOfBranches }},
+		{"time_zone", func(s *Store) interface{} { return &s.TimeZone }},
+		{"version", func(s *Store) interface{} { return &s.Version }},
+	},
+	fields: map[string][]string{
+		"id":               {"id"},
+		"createdAt":        {"created_at"},
+		"updatedAt":        {"updated_at"},
+		"title":            {"title"},
+		"description":      {"description"},
+		"address":          {"address"},
+		"latitude":         {"latitude"},
+		"longitude":        {"longitude"},
+		"numberOfBranches": {"number_of_branches"},
+		"timeZone":         {"time_zone"},
+	},
+}
@@ -6,16 +6,16 @@ import (
 
 func PopulateDatabase(models model.Models) error {
 	for _, product := range products {
-		models.Products.Insert(&product)
+		models.Products.Insert(&product, nil)
 	}
 
 	return nil
 }
 
 var products = []model.Products{
-	{Title: "iPhone 17 pro MAX", Description: "A new iPhone", ForWhatCountry: "JP", Price: 1499},
-	{Title: "MacBook Pro M4", Description: "Power of m4 CPU", ForWhatCountry: "USA", Price: 3500},
-	{Title: "airPods Max v2", Description: "Amazing sound", ForWhatCountry: "EU", Price: 700},
-	{Title: "iMac", Description: "Nice PC", ForWhatCountry: "USA", Price: 899},
-	{Title: "apple TV", Description: "Only sub use", ForWhatCountry: "CH", Price: 399},
+	{Title: "iPhone 17 pro MAX", Description: "A new iPhone", Countries: []string{"JP"}, Price: model.Money{Amount: 229800, Currency: "JPY"}},
+	{Title: "MacBook Pro M4", Description: "Power of m4 CPU", Countries: []string{"US"}, Price: model.Money{Amount: 350000, Currency: "USD"}},
+	{Title: "airPods Max v2", Description: "Amazing sound", Countries: []string{"EU"}, Price: model.Money{Amount: 69900, Currency: "EUR"}},
+	{Title: "iMac", Description: "Nice PC", Countries: []string{"US"}, Price: model.Money{Amount: 89900, Currency: "USD"}},
+	{Title: "apple TV", Description: "Only sub use", Countries: []string{"CH"}, Price: model.Money{Amount: 39900, Currency: "USD"}},
 }
@@ -1,7 +1,9 @@
 package model
 
 import (
+	"fmt"
 	"math"
+	"strconv"
 	"strings"
 
 	"github.com/kim0111/GoMidterm/pkg/apple/validator"
@@ -12,6 +14,34 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	// Fields limits the fields of the records read to the ones asked for, all of them if empty.
+	// Like Sort, they are checked against FieldSafeList.
+	Fields        []string
+	FieldSafeList []string
+	// Cursor selects the page by the position of the records instead of Page, if set. An empty
+	// Cursor selects the first page.
+	Cursor *Cursor
+	// Count is how the total number of records is found: CountExact (the default when empty),
+	// CountEstimated or CountNone.
+	Count string
+}
+
+// The ways a listing can find its total number of records. An exact count reads every matching
+// record on every page, an estimate only asks the query planner, and none skips the total.
+const (
+	CountExact     = "exact"
+	CountEstimated = "estimated"
+	CountNone      = "none"
+)
+
+// Cursor is a position in a sorted list: the sort value and the ID of the record the page starts
+// after, or before if it's Backward. Query ties the cursor to the filters it was made for.
+type Cursor struct {
+	Sort     string `json:"s"`
+	Key      string `json:"k"`
+	ID       int64  `json:"i"`
+	Backward bool   `json:"b,omitempty"`
+	Query    string `json:"q"`
 }
 
 // Metadata holds pagination metadata.
@@ -21,6 +51,14 @@ type Metadata struct {
 	FirstPage    int `json:"first_page,omitempty"`
 	LastPage     int `json:"last_page,omitempty"`
 	TotalRecords int `json:"total_records,omitempty"`
+	// TotalEstimated tells that TotalRecords and LastPage come from the query planner's estimate.
+	TotalEstimated bool `json:"total_estimated,omitempty"`
+	// NextCursor and PrevCursor are the cursors of the pages around a page selected by cursor.
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	// Next and Prev are the positions NextCursor and PrevCursor are made from.
+	Next *Cursor `json:"-"`
+	Prev *Cursor `json:"-"`
 }
 
 // calculateMetadata calculates the appropriate pagination metadata values given the total number
@@ -43,6 +81,12 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 
 // ValidateFilters runs validation checks on the Filters type.
 func ValidateFilters(v *validator.Validator, f Filters) {
+	// A page selected by cursor has no page number to check.
+	if f.Cursor != nil {
+		f.Page = 1
+		v.Check(f.Cursor.ID == 0 || f.Cursor.Sort == f.Sort, "cursor", "must be used with the sort it was made for")
+	}
+
 	// Check that page and page_size parameters contain sensible values.
 	v.Check(f.Page > 0, "page", "must be greater than 0")
 	v.Check(f.Page <= 10_000_0000, "", "must be a maximum of 10 million")
@@ -51,6 +95,10 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 
 	// Check that the sort parameter matches a value in the safelist.
 	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+
+	ValidateFields(v, f.Fields, f.FieldSafeList)
+
+	v.Check(validator.In(f.Count, "", CountExact, CountEstimated, CountNone), "count", "must be exact, estimated or none")
 }
 
 // sortColumn checks that the client-provided Sort field matches one of the entries in our
@@ -78,10 +126,158 @@ func (f Filters) sortDirection() string {
 	return "ASC"
 }
 
+// limit returns the number of records to read. A page selected by cursor reads one more, which
+// tells whether there is a page after it.
 func (f Filters) limit() int {
+	if f.Cursor != nil {
+		return f.PageSize + 1
+	}
 	return f.PageSize
 }
 
 func (f Filters) offset() int {
+	if f.Cursor != nil {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
+
+// sortColumns maps the sort values that are not named like their columns to the columns.
+var sortColumns = map[string]string{
+	"numberOfBranches": "number_of_branches",
+	"deletedAt":        "deleted_at",
+}
+
+// orderBy returns the ORDER BY expression for the Sort field. Sorting by relevance always puts the
+// best matches first and expects the query to select the rank as a "search_rank" column.
+func (f Filters) orderBy() string {
+	return f.sortKey() + " " + f.direction()
+}
+
+// sortKey returns the column the records are sorted by.
+func (f Filters) sortKey() string {
+	column := f.sortColumn()
+	if column == SortRelevance {
+		return "search_rank"
+	}
+
+	if name, ok := sortColumns[column]; ok {
+		column = name
+	}
+
+	return column
+}
+
+// direction returns the direction the records are sorted in, which is always descending for
+// relevance.
+func (f Filters) direction() string {
+	if f.Sort == SortRelevance {
+		return "DESC"
+	}
+	return f.sortDirection()
+}
+
+// pageOrderBy returns the ORDER BY expression of a page, with the ID breaking ties. A page before a
+// cursor is read in reverse order, from the cursor backwards.
+func (f Filters) pageOrderBy() string {
+	if f.Cursor != nil && f.Cursor.Backward {
+		direction := "DESC"
+		if f.direction() == "DESC" {
+			direction = "ASC"
+		}
+		return f.sortKey() + " " + direction + ", id DESC"
+	}
+
+	return f.orderBy() + ", id ASC"
+}
+
+// keyset returns the condition that selects the records after the cursor (or before a backward
+// one) in the sort order, with its placeholders numbered from n. It's TRUE without a cursor.
+func (f Filters) keyset(n int) (string, []interface{}) {
+	if f.Cursor == nil || f.Cursor.ID == 0 {
+		return "TRUE", nil
+	}
+
+	op, idOp := ">", ">"
+	if f.direction() == "DESC" {
+		op = "<"
+	}
+	if f.Cursor.Backward {
+		op, idOp = flipComparison(op), flipComparison(idOp)
+	}
+
+	condition := fmt.Sprintf("(%[1]s %[2]s $%[4]d OR (%[1]s = $%[4]d AND id %[3]s $%[5]d))", f.sortKey(), op, idOp, n, n+1)
+
+	return condition, []interface{}{f.Cursor.Key, f.Cursor.ID}
+}
+
+func flipComparison(op string) string {
+	if op == ">" {
+		return "<"
+	}
+	return ">"
+}
+
+// total returns the expression counting the records of a listing. Pages selected by cursor don't
+// count them, which is what makes them cheap, and neither do estimated or skipped counts.
+func (f Filters) total() string {
+	if f.Cursor != nil || f.Count == CountEstimated || f.Count == CountNone {
+		return "0"
+	}
+	return "count(*) OVER()"
+}
+
+// cursorKey is the sort value and the ID of a record of a page selected by cursor.
+type cursorKey struct {
+	value string
+	id    string
+}
+
+// cursorPage drops the extra record read by a page selected by cursor, puts the records of a
+// backward page back in the sort order, and returns the metadata with the cursors of the pages
+// before and after it. keys holds the sort value and ID of each record.
+func cursorPage[T any](f Filters, records []T, keys []cursorKey) ([]T, Metadata, error) {
+	metadata := Metadata{PageSize: f.PageSize}
+
+	more := len(records) > f.PageSize
+	if more {
+		records, keys = records[:f.PageSize], keys[:f.PageSize]
+	}
+
+	backward := f.Cursor.Backward
+	if backward {
+		for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+			records[i], records[j] = records[j], records[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	if len(records) == 0 {
+		return records, metadata, nil
+	}
+
+	cursor := func(key cursorKey, backward bool) (*Cursor, error) {
+		id, err := strconv.ParseInt(key.id, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &Cursor{Sort: f.Sort, Key: key.value, ID: id, Backward: backward}, nil
+	}
+
+	var err error
+
+	// A backward page always has the page it was reached from after it, a forward page has one
+	// before it unless it's the first.
+	if more || backward {
+		if metadata.Next, err = cursor(keys[len(keys)-1], false); err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+	if (more && backward) || (!backward && f.Cursor.ID != 0) {
+		if metadata.Prev, err = cursor(keys[0], true); err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	return records, metadata, nil
+}
@@ -0,0 +1,210 @@
+package model
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestKeyset(t *testing.T) {
+	tests := []struct {
+		name   string
+		sort   string
+		cursor *Cursor
+		want   string
+	}{
+		{
+			name: "no cursor",
+			sort: "price",
+			want: "TRUE",
+		},
+		{
+			name:   "first page",
+			sort:   "price",
+			cursor: &Cursor{},
+			want:   "TRUE",
+		},
+		{
+			name:   "forward, ascending",
+			sort:   "price",
+			cursor: &Cursor{Sort: "price", Key: "100", ID: 7},
+			want:   "(price > $3 OR (price = $3 AND id > $4))",
+		},
+		{
+			name:   "forward, descending",
+			sort:   "-price",
+			cursor: &Cursor{Sort: "-price", Key: "100", ID: 7},
+			want:   "(price < $3 OR (price = $3 AND id > $4))",
+		},
+		{
+			name:   "backward, ascending",
+			sort:   "price",
+			cursor: &Cursor{Sort: "price", Key: "100", ID: 7, Backward: true},
+			want:   "(price < $3 OR (price = $3 AND id < $4))",
+		},
+		{
+			name:   "backward, descending",
+			sort:   "-price",
+			cursor: &Cursor{Sort: "-price", Key: "100", ID: 7, Backward: true},
+			want:   "(price > $3 OR (price = $3 AND id < $4))",
+		},
+		{
+			name:   "forward, relevance",
+			sort:   SortRelevance,
+			cursor: &Cursor{Sort: SortRelevance, Key: "0.5", ID: 7},
+			want:   "(search_rank < $3 OR (search_rank = $3 AND id > $4))",
+		},
+		{
+			name:   "backward, relevance",
+			sort:   SortRelevance,
+			cursor: &Cursor{Sort: SortRelevance, Key: "0.5", ID: 7, Backward: true},
+			want:   "(search_rank > $3 OR (search_rank = $3 AND id < $4))",
+		},
+		{
+			name:   "renamed column",
+			sort:   "-numberOfBranches",
+			cursor: &Cursor{Sort: "-numberOfBranches", Key: "2", ID: 7},
+			want:   "(number_of_branches < $3 OR (number_of_branches = $3 AND id > $4))",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filters{Sort: tt.sort, SortSafeList: []string{tt.sort}, Cursor: tt.cursor}
+
+			got, args := f.keyset(3)
+			if got != tt.want {
+				t.Errorf("keyset(3) = %q, want %q", got, tt.want)
+			}
+
+			var wantArgs []interface{}
+			if tt.want != "TRUE" {
+				wantArgs = []interface{}{tt.cursor.Key, tt.cursor.ID}
+			}
+			if !reflect.DeepEqual(args, wantArgs) {
+				t.Errorf("keyset(3) args = %v, want %v", args, wantArgs)
+			}
+		})
+	}
+}
+
+func TestPageOrderBy(t *testing.T) {
+	tests := []struct {
+		name   string
+		sort   string
+		cursor *Cursor
+		want   string
+	}{
+		{name: "numbered page", sort: "price", want: "price ASC, id ASC"},
+		{name: "forward, ascending", sort: "price", cursor: &Cursor{ID: 7}, want: "price ASC, id ASC"},
+		{name: "forward, descending", sort: "-price", cursor: &Cursor{ID: 7}, want: "price DESC, id ASC"},
+		{name: "backward, ascending", sort: "price", cursor: &Cursor{ID: 7, Backward: true}, want: "price DESC, id DESC"},
+		{name: "backward, descending", sort: "-price", cursor: &Cursor{ID: 7, Backward: true}, want: "price ASC, id DESC"},
+		{name: "forward, relevance", sort: SortRelevance, cursor: &Cursor{ID: 7}, want: "search_rank DESC, id ASC"},
+		{name: "backward, relevance", sort: SortRelevance, cursor: &Cursor{ID: 7, Backward: true}, want: "search_rank ASC, id DESC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filters{Sort: tt.sort, SortSafeList: []string{tt.sort}, Cursor: tt.cursor}
+
+			if got := f.pageOrderBy(); got != tt.want {
+				t.Errorf("pageOrderBy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursorPage(t *testing.T) {
+	const pageSize = 3
+
+	tests := []struct {
+		name string
+		// cursor selects the page, ids are the records read for it in the order they were read,
+		// up to one more than the page size.
+		cursor   Cursor
+		ids      []int64
+		wantIDs  []int64
+		wantNext *Cursor
+		wantPrev *Cursor
+	}{
+		{
+			name:     "first page",
+			cursor:   Cursor{},
+			ids:      []int64{1, 2, 3, 4},
+			wantIDs:  []int64{1, 2, 3},
+			wantNext: &Cursor{Sort: "price", Key: "30", ID: 3},
+		},
+		{
+			name:    "only page",
+			cursor:  Cursor{},
+			ids:     []int64{1, 2},
+			wantIDs: []int64{1, 2},
+		},
+		{
+			name:     "forward, middle page",
+			cursor:   Cursor{Sort: "price", Key: "30", ID: 3},
+			ids:      []int64{4, 5, 6, 7},
+			wantIDs:  []int64{4, 5, 6},
+			wantNext: &Cursor{Sort: "price", Key: "60", ID: 6},
+			wantPrev: &Cursor{Sort: "price", Key: "40", ID: 4, Backward: true},
+		},
+		{
+			name:     "forward, last page",
+			cursor:   Cursor{Sort: "price", Key: "60", ID: 6},
+			ids:      []int64{7},
+			wantIDs:  []int64{7},
+			wantPrev: &Cursor{Sort: "price", Key: "70", ID: 7, Backward: true},
+		},
+		{
+			name:     "backward, middle page",
+			cursor:   Cursor{Sort: "price", Key: "70", ID: 7, Backward: true},
+			ids:      []int64{6, 5, 4, 3},
+			wantIDs:  []int64{4, 5, 6},
+			wantNext: &Cursor{Sort: "price", Key: "60", ID: 6},
+			wantPrev: &Cursor{Sort: "price", Key: "40", ID: 4, Backward: true},
+		},
+		{
+			name:     "backward, first page",
+			cursor:   Cursor{Sort: "price", Key: "40", ID: 4, Backward: true},
+			ids:      []int64{3, 2, 1},
+			wantIDs:  []int64{1, 2, 3},
+			wantNext: &Cursor{Sort: "price", Key: "30", ID: 3},
+		},
+		{
+			name:   "nothing after the cursor",
+			cursor: Cursor{Sort: "price", Key: "70", ID: 7},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := tt.cursor
+			f := Filters{PageSize: pageSize, Sort: "price", SortSafeList: []string{"price"}, Cursor: &cursor}
+
+			// The sort value of each record is ten times its ID.
+			keys := make([]cursorKey, len(tt.ids))
+			for i, id := range tt.ids {
+				keys[i] = cursorKey{value: strconv.FormatInt(id*10, 10), id: strconv.FormatInt(id, 10)}
+			}
+
+			ids, metadata, err := cursorPage(f, append([]int64(nil), tt.ids...), keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(ids) != len(tt.wantIDs) || (len(ids) > 0 && !reflect.DeepEqual(ids, tt.wantIDs)) {
+				t.Errorf("records = %v, want %v", ids, tt.wantIDs)
+			}
+			if !reflect.DeepEqual(metadata.Next, tt.wantNext) {
+				t.Errorf("next = %+v, want %+v", metadata.Next, tt.wantNext)
+			}
+			if !reflect.DeepEqual(metadata.Prev, tt.wantPrev) {
+				t.Errorf("prev = %+v, want %+v", metadata.Prev, tt.wantPrev)
+			}
+			if metadata.PageSize != pageSize {
+				t.Errorf("page size = %d, want %d", metadata.PageSize, pageSize)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"log"
@@ -16,11 +17,23 @@ var (
 )
 
 type Models struct {
-	Products    ProductModel
-	Stores      StoreModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Products      ProductModel
+	Variants      VariantModel
+	Images        ProductImageModel
+	Stores        StoreModel
+	StoreHours    StoreHoursModel
+	Branches      BranchModel
+	Categories    CategoryModel
+	StoreProducts StoreProductModel
+	Reservations  ReservationModel
+	Orders        OrderModel
+	ExchangeRates ExchangeRateModel
+	Search        SearchModel
+	Trash         TrashModel
+	Audit         AuditModel
+	Users         UserModel
+	Tokens        TokenModel
+	Permissions   PermissionModel
 }
 
 func NewModels(db *sql.DB) Models {
@@ -32,11 +45,72 @@ func NewModels(db *sql.DB) Models {
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
 		},
+		Variants: VariantModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Images: ProductImageModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
 		Stores: StoreModel{
 			DB:       db,
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
-		}, Users: UserModel{
+		},
+		StoreHours: StoreHoursModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Branches: BranchModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Categories: CategoryModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		StoreProducts: StoreProductModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Reservations: ReservationModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Orders: OrderModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		ExchangeRates: ExchangeRateModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Search: SearchModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Trash: TrashModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Audit: AuditModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Users: UserModel{
 			DB:       db,
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
@@ -53,3 +127,43 @@ func NewModels(db *sql.DB) Models {
 		},
 	}
 }
+
+// withTx runs fn inside a single database transaction. The transaction is committed when fn
+// returns nil and rolled back otherwise, so fn never has to care about either.
+func withTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		// The rollback error is not interesting here, the one returned by fn is.
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// rowQuerier is what *sql.DB and *sql.Tx have in common for reading a single row.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// versionMismatch tells why a change of a record made at the version it was read at didn't match
+// any row of the table: it returns ErrRecordNotFound if the record is gone or was moved to the
+// trash in the meantime, and ErrEditConflict if it was changed.
+func versionMismatch(ctx context.Context, db rowQuerier, table string, id interface{}) error {
+	var deleted bool
+	err := db.QueryRowContext(ctx, `SELECT deleted_at IS NOT NULL FROM `+table+` WHERE id = $1`, id).Scan(&deleted)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrRecordNotFound
+	case err != nil:
+		return err
+	case deleted:
+		return ErrRecordNotFound
+	default:
+		return ErrEditConflict
+	}
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// BaseCurrency is the currency every exchange rate is quoted against.
+const BaseCurrency = "USD"
+
+// ErrNoExchangeRate is returned when an amount can't be converted because there is no exchange
+// rate for one of the currencies.
+var ErrNoExchangeRate = errors.New("no exchange rate for currency")
+
+// currencyExponents maps the supported ISO 4217 currency codes to the number of decimal places of
+// their minor unit, e.g. 2 for USD (cents) and 0 for JPY.
+var currencyExponents = map[string]int{
+	"AUD": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"KZT": 2,
+	"USD": 2,
+}
+
+// Money is an amount of money in the minor units of its ISO 4217 currency, so 1499.99 USD is
+// {Amount: 149999, Currency: "USD"} and 1499 JPY is {Amount: 1499, Currency: "JPY"}.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// String formats the amount in major units followed by the currency code, e.g. "1499.99 USD".
+func (m Money) String() string {
+	exp := currencyExponents[m.Currency]
+	if exp == 0 {
+		return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+	}
+
+	return fmt.Sprintf("%s %s", new(big.Rat).SetFrac64(m.Amount, pow10(exp)).FloatString(exp), m.Currency)
+}
+
+// IsCurrency reports whether code is a supported ISO 4217 currency code.
+func IsCurrency(code string) bool {
+	_, ok := currencyExponents[code]
+	return ok
+}
+
+// Currencies returns the supported ISO 4217 currency codes.
+func Currencies() []string {
+	codes := make([]string, 0, len(currencyExponents))
+	for code := range currencyExponents {
+		codes = append(codes, code)
+	}
+
+	return codes
+}
+
+// ExchangeRates maps currency codes to the number of units of that currency one unit of the
+// BaseCurrency buys. The values are exact decimals as stored in the exchange_rates table.
+type ExchangeRates map[string]*big.Rat
+
+// Convert converts the amount into the target currency, rounding half away from zero to the
+// minor unit of the target currency. It returns ErrNoExchangeRate if either rate is missing.
+func (m Money) Convert(currency string, rates ExchangeRates) (Money, error) {
+	if m.Currency == currency {
+		return m, nil
+	}
+
+	from, ok := rates[m.Currency]
+	if !ok || !IsCurrency(currency) {
+		return Money{}, fmt.Errorf("%w %s", ErrNoExchangeRate, m.Currency)
+	}
+	to, ok := rates[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w %s", ErrNoExchangeRate, currency)
+	}
+
+	// amount / 10^fromExp / from * to * 10^toExp
+	amount := new(big.Rat).SetFrac64(m.Amount, pow10(currencyExponents[m.Currency]))
+	amount.Quo(amount, from)
+	amount.Mul(amount, to)
+	amount.Mul(amount, new(big.Rat).SetInt64(pow10(currencyExponents[currency])))
+
+	return Money{Amount: roundRat(amount), Currency: currency}, nil
+}
+
+// roundRat rounds r half away from zero to an integer.
+func roundRat(r *big.Rat) int64 {
+	num := new(big.Int).Abs(r.Num())
+	den := r.Denom()
+
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Mul(rem, big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+
+	if r.Sign() < 0 {
+		q.Neg(q)
+	}
+
+	return q.Int64()
+}
+
+func pow10(exp int) int64 {
+	n := int64(1)
+	for i := 0; i < exp; i++ {
+		n *= 10
+	}
+
+	return n
+}
+
+// ValidateMoney checks that the amount is not negative, not more than max major units and that
+// the currency is supported. The key is used for the validation error messages.
+func ValidateMoney(v *validator.Validator, key string, m Money, max int64) {
+	v.Check(m.Currency != "", key, "must have a currency")
+	v.Check(m.Currency == "" || IsCurrency(m.Currency), key, "must have a supported ISO 4217 currency")
+	v.Check(m.Amount >= 0, key, "must not be negative")
+	v.Check(m.Amount <= max*pow10(currencyExponents[m.Currency]), key, fmt.Sprintf("must not be more than %d", max))
+}
@@ -0,0 +1,495 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+	"github.com/lib/pq"
+)
+
+// Order statuses. A freshly checked out order is pending.
+const (
+	OrderPending        = "pending"
+	OrderPaid           = "paid"
+	OrderReadyForPickup = "ready_for_pickup"
+	OrderShipped        = "shipped"
+	OrderCompleted      = "completed"
+	OrderCancelled      = "cancelled"
+	OrderRefunded       = "refunded"
+)
+
+var (
+	// ErrProductUnavailable is returned on checkout when the store doesn't carry one of the
+	// ordered products.
+	ErrProductUnavailable = errors.New("product is not available in the store")
+
+	// ErrMixedCurrencies is returned on checkout when the ordered products are priced in more
+	// than one currency.
+	ErrMixedCurrencies = errors.New("products are priced in different currencies")
+
+	// ErrInvalidTransition is returned when an order can't be moved to the requested status from
+	// the status it is in.
+	ErrInvalidTransition = errors.New("invalid status transition")
+)
+
+// orderTransitions is the state machine of an order: it maps every status to the statuses the
+// order may be moved to from it. Cancelled and refunded orders are final.
+var orderTransitions = map[string][]string{
+	OrderPending:        {OrderPaid, OrderCancelled},
+	OrderPaid:           {OrderReadyForPickup, OrderShipped, OrderRefunded},
+	OrderReadyForPickup: {OrderCompleted, OrderRefunded},
+	OrderShipped:        {OrderCompleted, OrderRefunded},
+	OrderCompleted:      {OrderRefunded},
+	OrderCancelled:      {},
+	OrderRefunded:       {},
+}
+
+// OrderStatuses returns every known order status.
+func OrderStatuses() []string {
+	return []string{OrderPending, OrderPaid, OrderReadyForPickup, OrderShipped, OrderCompleted, OrderCancelled, OrderRefunded}
+}
+
+// CanTransition reports whether an order in the from status may be moved to the to status.
+func CanTransition(from, to string) bool {
+	return validator.In(to, orderTransitions[from]...)
+}
+
+// restocksOnTransition reports whether moving an order from the from status to the to status puts
+// the ordered items back into the store stock, which is the case as long as the items haven't
+// left the store yet.
+func restocksOnTransition(from, to string) bool {
+	if to != OrderCancelled && to != OrderRefunded {
+		return false
+	}
+
+	return validator.In(from, OrderPending, OrderPaid, OrderReadyForPickup)
+}
+
+// OrderEvent is a single status change in the history of an order.
+type OrderEvent struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"createdAt"`
+	OrderID    int64     `json:"orderId"`
+	FromStatus *string   `json:"fromStatus"`
+	ToStatus   string    `json:"toStatus"`
+	ActorID    *int64    `json:"actorId"`
+	Note       string    `json:"note"`
+}
+
+// Order is a checkout of a set of products from a single store by a user.
+type Order struct {
+	ID        int64        `json:"id"`
+	CreatedAt time.Time    `json:"createdAt"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+	UserID    int64        `json:"userId"`
+	StoreID   *int64       `json:"storeId"`
+	Status    string       `json:"status"`
+	Total     int64        `json:"total"`
+	Currency  string       `json:"currency"`
+	Lines     []*OrderLine `json:"lines"`
+}
+
+// OrderLine is a single product, or variant of a product, of an order. Title, SKU and UnitPrice
+// are snapshotted at checkout time. UnitPrice, like the Total of the order, is in the minor units
+// of the order currency.
+type OrderLine struct {
+	ID        int64  `json:"id"`
+	ProductID *int64 `json:"productId"`
+	VariantID *int64 `json:"variantId"`
+	SKU       string `json:"sku,omitempty"`
+	Title     string `json:"title"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice int64  `json:"unitPrice"`
+}
+
+type OrderModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert checks out the order. In a single transaction it locks the stock rows of the ordered
+// products, takes the quantities out of the store stock, snapshots the current product prices and
+// writes the order with its lines. It returns ErrProductUnavailable if the store doesn't carry one
+// of the products, ErrInsufficientStock if there is not enough of it left and ErrMixedCurrencies if
+// the products are not all priced in the same currency.
+func (m OrderModel) Insert(order *Order) error {
+	// Lock the stock rows always in the same order, so two concurrent checkouts of the same
+	// products can't deadlock each other.
+	sort.Slice(order.Lines, func(i, j int) bool {
+		a, b := order.Lines[i], order.Lines[j]
+		if *a.ProductID != *b.ProductID {
+			return *a.ProductID < *b.ProductID
+		}
+		return variantKey(a.VariantID) < variantKey(b.VariantID)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		order.Total = 0
+		order.Currency = ""
+
+		for _, line := range order.Lines {
+			var available int
+			var currency string
+			// A variant is sold for its price override, if it has one.
+			err := tx.QueryRowContext(ctx, `
+				SELECT sp.quantity, p.title, coalesce(v.sku, ''), coalesce(v.price, p.price), coalesce(v.currency, p.currency)
+				FROM stores_and_products sp
+					INNER JOIN products p ON p.id = sp.product
+					LEFT JOIN product_variants v ON v.id = sp.variant_id
+				WHERE sp.store = $1 AND sp.product = $2 AND coalesce(sp.variant_id, 0) = $3 AND p.deleted_at IS NULL
+				FOR UPDATE OF sp
+				`, order.StoreID, line.ProductID, variantKey(line.VariantID)).Scan(&available, &line.Title, &line.SKU, &line.UnitPrice, &currency)
+			if err != nil {
+				switch {
+				case errors.Is(err, sql.ErrNoRows):
+					return ErrProductUnavailable
+				default:
+					return err
+				}
+			}
+
+			if available < line.Quantity {
+				return ErrInsufficientStock
+			}
+
+			if order.Currency != "" && order.Currency != currency {
+				return ErrMixedCurrencies
+			}
+			order.Currency = currency
+
+			_, err = tx.ExecContext(ctx, `
+				UPDATE stores_and_products
+				SET quantity = quantity - $4, updated_at = CURRENT_TIMESTAMP
+				WHERE store = $1 AND product = $2 AND coalesce(variant_id, 0) = $3
+				`, order.StoreID, line.ProductID, variantKey(line.VariantID), line.Quantity)
+			if err != nil {
+				return err
+			}
+
+			order.Total += line.UnitPrice * int64(line.Quantity)
+		}
+
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO orders (user_id, store_id, status, total, currency)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, created_at, updated_at, status
+			`, order.UserID, order.StoreID, OrderPending, order.Total, order.Currency).Scan(&order.ID, &order.CreatedAt, &order.UpdatedAt, &order.Status)
+		if err != nil {
+			return err
+		}
+
+		// The checkout is the first event in the history of the order.
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO order_events (order_id, to_status, actor_id)
+			VALUES ($1, $2, $3)
+			`, order.ID, order.Status, order.UserID)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range order.Lines {
+			err := tx.QueryRowContext(ctx, `
+				INSERT INTO order_lines (order_id, product_id, variant_id, sku, title, quantity, unit_price)
+				VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7)
+				RETURNING id
+				`, order.ID, line.ProductID, line.VariantID, line.SKU, line.Title, line.Quantity, line.UnitPrice).Scan(&line.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Get returns an order together with its lines.
+func (m OrderModel) Get(id int64) (*Order, error) {
+	return m.get(id, 0)
+}
+
+// GetForUser returns an order of the given user together with its lines.
+func (m OrderModel) GetForUser(id, userID int64) (*Order, error) {
+	if userID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.get(id, userID)
+}
+
+// get returns the order with the given id. If userID is not 0 only the orders of that user are
+// considered.
+func (m OrderModel) get(id, userID int64) (*Order, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, updated_at, user_id, store_id, status, total, currency
+		FROM orders
+		WHERE id = $1 AND (user_id = $2 OR $2 = 0)
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var order Order
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&order.ID, &order.CreatedAt, &order.UpdatedAt, &order.UserID, &order.StoreID, &order.Status, &order.Total, &order.Currency)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if err := m.loadLines(ctx, []*Order{&order}); err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// GetAllForUser returns a page of the orders of the given user.
+func (m OrderModel) GetAllForUser(userID int64, filters Filters) ([]*Order, Metadata, error) {
+	query := fmt.Sprintf(
+		`
+		SELECT count(*) OVER(), id, created_at, updated_at, user_id, store_id, status, total, currency
+		FROM orders
+		WHERE user_id = $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3
+		`,
+		filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+
+	orders := []*Order{}
+	for rows.Next() {
+		var order Order
+		err := rows.Scan(&totalRecords, &order.ID, &order.CreatedAt, &order.UpdatedAt, &order.UserID, &order.StoreID, &order.Status, &order.Total, &order.Currency)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		orders = append(orders, &order)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	if err := m.loadLines(ctx, orders); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return orders, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// UpdateStatus moves the order to the given status on behalf of the actor and records the change
+// in the order history. It returns ErrInvalidTransition if the state machine doesn't allow the
+// change. Cancelling or refunding an order whose items are still in the store puts them back into
+// the store stock.
+func (m OrderModel) UpdateStatus(id int64, status string, actorID int64, note string) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		// Lock the order, so two concurrent changes can't both pass the transition check.
+		var current string
+		var storeID *int64
+		err := tx.QueryRowContext(ctx, `
+			SELECT status, store_id
+			FROM orders
+			WHERE id = $1
+			FOR UPDATE
+			`, id).Scan(&current, &storeID)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		if !CanTransition(current, status) {
+			return fmt.Errorf("%w from %q to %q", ErrInvalidTransition, current, status)
+		}
+
+		if storeID != nil && restocksOnTransition(current, status) {
+			// Lines of variants that were deleted since (a SKU, but no variant any more) have no
+			// stock row left to go back to.
+			_, err = tx.ExecContext(ctx, `
+				UPDATE stores_and_products sp
+				SET quantity = sp.quantity + ol.quantity, updated_at = CURRENT_TIMESTAMP
+				FROM order_lines ol
+				WHERE ol.order_id = $1 AND sp.store = $2 AND sp.product = ol.product_id
+				AND coalesce(sp.variant_id, 0) = coalesce(ol.variant_id, 0)
+				AND (ol.variant_id IS NOT NULL OR ol.sku IS NULL)
+				`, id, *storeID)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE orders
+			SET status = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+			`, id, status)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO order_events (order_id, from_status, to_status, actor_id, note)
+			VALUES ($1, $2, $3, $4, $5)
+			`, id, current, status, actorID, note)
+		return err
+	})
+}
+
+// GetEvents returns the status history of an order, oldest first.
+func (m OrderModel) GetEvents(orderID int64) ([]*OrderEvent, error) {
+	query := `
+		SELECT id, created_at, order_id, from_status, to_status, actor_id, note
+		FROM order_events
+		WHERE order_id = $1
+		ORDER BY id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	events := []*OrderEvent{}
+	for rows.Next() {
+		var event OrderEvent
+		err := rows.Scan(&event.ID, &event.CreatedAt, &event.OrderID, &event.FromStatus, &event.ToStatus, &event.ActorID, &event.Note)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// loadLines fills the lines of the given orders with a single query.
+func (m OrderModel) loadLines(ctx context.Context, orders []*Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*Order, len(orders))
+	ids := make([]int64, 0, len(orders))
+	for _, order := range orders {
+		order.Lines = []*OrderLine{}
+		byID[order.ID] = order
+		ids = append(ids, order.ID)
+	}
+
+	query := `
+		SELECT order_id, id, product_id, variant_id, coalesce(sku, ''), title, quantity, unit_price
+		FROM order_lines
+		WHERE order_id = ANY($1)
+		ORDER BY id
+		`
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	for rows.Next() {
+		var orderID int64
+		var line OrderLine
+		err := rows.Scan(&orderID, &line.ID, &line.ProductID, &line.VariantID, &line.SKU, &line.Title, &line.Quantity, &line.UnitPrice)
+		if err != nil {
+			return err
+		}
+
+		byID[orderID].Lines = append(byID[orderID].Lines, &line)
+	}
+
+	return rows.Err()
+}
+
+func ValidateOrder(v *validator.Validator, order *Order) {
+	v.Check(order.StoreID != nil && *order.StoreID > 0, "storeId", "must be provided")
+	v.Check(len(order.Lines) > 0, "items", "must contain at least 1 product")
+	v.Check(len(order.Lines) <= 50, "items", "must not contain more than 50 products")
+
+	seen := make(map[[2]int64]bool, len(order.Lines))
+	for _, line := range order.Lines {
+		if line.ProductID == nil || *line.ProductID < 1 {
+			v.AddError("items", "must only contain valid product ids")
+			continue
+		}
+		if line.VariantID != nil && *line.VariantID < 1 {
+			v.AddError("items", "must only contain valid variant ids")
+			continue
+		}
+
+		key := [2]int64{*line.ProductID, variantKey(line.VariantID)}
+		v.Check(!seen[key], "items", "must not contain duplicate products")
+		seen[key] = true
+
+		v.Check(line.Quantity > 0, "items", "must only contain quantities greater than 0")
+		v.Check(line.Quantity <= 100, "items", "must not contain quantities of more than 100")
+	}
+}
+
+func ValidateOrderStatus(v *validator.Validator, status, note string) {
+	v.Check(status != "", "status", "must be provided")
+	v.Check(validator.In(status, OrderStatuses()...), "status", "must be a known order status")
+	v.Check(len(note) <= 500, "note", "must not be more than 500 bytes long")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/lib/pq"
@@ -72,8 +73,9 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
-// AddForUser adds the provided codes for a specific user.
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+// AddForUser adds the provided codes for a specific user. The grant is recorded in the audit log
+// with the given entry, if any.
+func (m PermissionModel) AddForUser(userID int64, audit *AuditEntry, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
@@ -82,6 +84,12 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
+	return withTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, userID, pq.Array(codes))
+		if err != nil {
+			return err
+		}
+
+		return audit.record(ctx, tx, strconv.FormatInt(userID, 10), map[string][]string{"codes": codes})
+	})
 }
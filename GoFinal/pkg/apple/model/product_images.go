@@ -0,0 +1,288 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+	"github.com/lib/pq"
+)
+
+// ProductImage is an uploaded image of a product. The image and its thumbnail are kept in the file
+// storage under StorageKey and ThumbnailKey. Images are shown in Position order and the primary
+// one is the one shown in listings.
+type ProductImage struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"createdAt"`
+	ProductID    int64     `json:"productId"`
+	ContentType  string    `json:"contentType"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	Checksum     string    `json:"-"`
+	StorageKey   string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	Position     int       `json:"position"`
+	Primary      bool      `json:"primary"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnailUrl"`
+}
+
+type ProductImageModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// GetAllForProduct returns the images of the product in display order.
+func (m ProductImageModel) GetAllForProduct(productID int64) ([]*ProductImage, error) {
+	query := `
+		SELECT id, created_at, product_id, content_type, size, width, height, checksum, storage_key, thumbnail_key, position, is_primary
+		FROM product_images
+		WHERE product_id = $1
+		ORDER BY position, id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	images := []*ProductImage{}
+	for rows.Next() {
+		var image ProductImage
+		err := rows.Scan(&image.ID, &image.CreatedAt, &image.ProductID, &image.ContentType, &image.Size, &image.Width, &image.Height,
+			&image.Checksum, &image.StorageKey, &image.ThumbnailKey, &image.Position, &image.Primary)
+		if err != nil {
+			return nil, err
+		}
+
+		images = append(images, &image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// Get returns an image of the product.
+func (m ProductImageModel) Get(productID, id int64) (*ProductImage, error) {
+	if productID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, product_id, content_type, size, width, height, checksum, storage_key, thumbnail_key, position, is_primary
+		FROM product_images
+		WHERE product_id = $1 AND id = $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var image ProductImage
+	err := m.DB.QueryRowContext(ctx, query, productID, id).Scan(&image.ID, &image.CreatedAt, &image.ProductID, &image.ContentType, &image.Size,
+		&image.Width, &image.Height, &image.Checksum, &image.StorageKey, &image.ThumbnailKey, &image.Position, &image.Primary)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &image, nil
+}
+
+// Insert adds the image as the last one of the product. The first image of a product always
+// becomes the primary one, a later one only when Primary is set.
+func (m ProductImageModel) Insert(image *ProductImage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		// Lock the product, so concurrent uploads get distinct positions.
+		var count int
+		err := tx.QueryRowContext(ctx, `
+			SELECT (SELECT count(*) FROM product_images WHERE product_id = p.id)
+			FROM products p
+			WHERE p.id = $1
+			FOR UPDATE
+			`, image.ProductID).Scan(&count)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		if count == 0 {
+			image.Primary = true
+		}
+
+		if image.Primary {
+			if err := clearPrimaryImage(ctx, tx, image.ProductID); err != nil {
+				return err
+			}
+		}
+
+		query := `
+			INSERT INTO product_images (product_id, content_type, size, width, height, checksum, storage_key, thumbnail_key, position, is_primary)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8,
+				(SELECT coalesce(max(position), 0) + 1 FROM product_images WHERE product_id = $1), $9)
+			RETURNING id, created_at, position
+			`
+		args := []interface{}{image.ProductID, image.ContentType, image.Size, image.Width, image.Height, image.Checksum,
+			image.StorageKey, image.ThumbnailKey, image.Primary}
+
+		return tx.QueryRowContext(ctx, query, args...).Scan(&image.ID, &image.CreatedAt, &image.Position)
+	})
+}
+
+// SetPrimary makes the image the primary one of its product.
+func (m ProductImageModel) SetPrimary(productID, id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		if err := clearPrimaryImage(ctx, tx, productID); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			UPDATE product_images
+			SET is_primary = true
+			WHERE product_id = $1 AND id = $2
+			`, productID, id)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+// Reorder puts the images of the product into the order of ids, which has to list every image of
+// the product exactly once. It returns ErrRecordNotFound otherwise.
+func (m ProductImageModel) Reorder(productID int64, ids []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		// The position of an image is its 1-based index in ids. The count check makes sure that
+		// no image of the product is left out.
+		result, err := tx.ExecContext(ctx, `
+			UPDATE product_images pi
+			SET position = ord.position
+			FROM unnest($2::bigint[]) WITH ORDINALITY AS ord (id, position)
+			WHERE pi.product_id = $1 AND pi.id = ord.id
+			AND (SELECT count(*) FROM product_images WHERE product_id = $1) = cardinality($2::bigint[])
+			`, productID, pq.Array(ids))
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected != int64(len(ids)) {
+			return ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+// Delete removes an image of the product and returns it, so its files can be removed from the
+// storage. If it was the primary image, the next image in order becomes the primary one.
+func (m ProductImageModel) Delete(productID, id int64) (*ProductImage, error) {
+	image, err := m.Get(productID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = withTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			DELETE FROM product_images
+			WHERE product_id = $1 AND id = $2
+			`, productID, id)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+
+		if !image.Primary {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE product_images
+			SET is_primary = true
+			WHERE id = (SELECT id FROM product_images WHERE product_id = $1 ORDER BY position, id LIMIT 1)
+			`, productID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return image, nil
+}
+
+func clearPrimaryImage(ctx context.Context, tx *sql.Tx, productID int64) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE product_images
+		SET is_primary = false
+		WHERE product_id = $1 AND is_primary
+		`, productID)
+	return err
+}
+
+func ValidateImageOrder(v *validator.Validator, ids []int64) {
+	v.Check(len(ids) > 0, "imageIds", "must contain at least 1 image")
+
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		v.Check(id > 0, "imageIds", "must only contain valid image ids")
+		v.Check(!seen[id], "imageIds", "must not contain duplicate image ids")
+		seen[id] = true
+	}
+}
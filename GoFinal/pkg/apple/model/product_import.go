@@ -0,0 +1,483 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrImportFailed is returned by an atomic import that was rolled back because some rows couldn't
+// be imported. The report tells which.
+var ErrImportFailed = errors.New("import failed")
+
+const (
+	// ImportAtomic imports every row or, if any row fails, none.
+	ImportAtomic = "atomic"
+	// ImportBestEffort imports the rows that can be imported and reports the others as failed.
+	ImportBestEffort = "best_effort"
+
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportFailed  = "failed"
+	// ImportSkipped marks the valid rows of an atomic import that was rolled back.
+	ImportSkipped = "skipped"
+
+	// copyThreshold is the number of new products from which they are loaded with COPY instead of
+	// an INSERT each.
+	copyThreshold = 500
+
+	// importBatchSize is the number of rows read from an import file before they are written.
+	importBatchSize = 1000
+
+	// importTimeout bounds the whole import, reading the file included, which runs in a single
+	// transaction.
+	importTimeout = 2 * time.Minute
+)
+
+// ImportRow is a product read from a row of an import file. A product with an ID updates the
+// existing product, one without is created.
+type ImportRow struct {
+	// Line is the line of the file the row starts on.
+	Line    int
+	Product *Products
+	// Errors holds the reasons the row can't be imported, if any.
+	Errors map[string]string
+}
+
+func (row *ImportRow) addError(key, message string) {
+	if row.Errors == nil {
+		row.Errors = make(map[string]string)
+	}
+	if _, exists := row.Errors[key]; !exists {
+		row.Errors[key] = message
+	}
+}
+
+// ImportResult is the outcome of an import row.
+type ImportResult struct {
+	Line   int               `json:"line"`
+	Status string            `json:"status"`
+	ID     string            `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// ImportReport is the outcome of an import.
+type ImportReport struct {
+	Mode    string          `json:"mode"`
+	Created int             `json:"created"`
+	Updated int             `json:"updated"`
+	Failed  int             `json:"failed"`
+	Skipped int             `json:"skipped"`
+	Rows    []*ImportResult `json:"rows"`
+}
+
+// Import creates and updates products in a single transaction from the rows returned by next,
+// which returns io.EOF after the last one. The rows are read and written in batches of
+// importBatchSize, so only a batch of them is held in memory at a time. Rows that already have
+// errors are not imported, and neither are the rows the database turns down, e.g. for a
+// constraint they break. In atomic mode nothing is written unless every row can be imported, and
+// ErrImportFailed is returned together with the report; the rest of the rows are still read, to
+// be reported on. In best effort mode each row is written within a savepoint, so a failed row is
+// rolled back on its own and the import goes on. An import that creates or updates products is
+// recorded as a whole in the audit log with the given entry, if any, with the number of products
+// it created and updated. An error returned by next stops the import and is returned as it is.
+func (p ProductModel) Import(next func() (*ImportRow, error), mode string, audit *AuditEntry) (*ImportReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), importTimeout)
+	defer cancel()
+
+	report := &ImportReport{Mode: mode}
+
+	err := withTx(ctx, p.DB, nil, func(tx *sql.Tx) error {
+		// failed is set once an atomic import can no longer succeed. From then on nothing is written,
+		// the transaction may be aborted already.
+		failed := false
+
+		for done := false; !done; {
+			var batch []*ImportRow
+			var err error
+			batch, done, err = readImportBatch(next)
+			if err != nil {
+				return err
+			}
+
+			// Remember which rows are updates, the created products get their IDs during the import.
+			updates := make([]bool, len(batch))
+			for i, row := range batch {
+				updates[i] = row.Product.Id != ""
+			}
+
+			if !failed {
+				err := p.importBatch(ctx, tx, batch, mode)
+				if err != nil && !errors.Is(err, ErrImportFailed) {
+					return err
+				}
+				failed = err != nil
+			}
+
+			for i, row := range batch {
+				report.add(row, updates[i], failed)
+			}
+		}
+
+		if failed {
+			report.skipImported()
+			return ErrImportFailed
+		}
+
+		if report.Created == 0 && report.Updated == 0 {
+			return nil
+		}
+
+		return audit.record(ctx, tx, "", map[string]int{"created": report.Created, "updated": report.Updated})
+	})
+	if err != nil && !errors.Is(err, ErrImportFailed) {
+		return nil, err
+	}
+
+	return report, err
+}
+
+// readImportBatch reads up to importBatchSize rows with next, and tells whether it got to the end.
+func readImportBatch(next func() (*ImportRow, error)) ([]*ImportRow, bool, error) {
+	batch := make([]*ImportRow, 0, importBatchSize)
+	for len(batch) < importBatchSize {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			return batch, true, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		batch = append(batch, row)
+	}
+
+	return batch, false, nil
+}
+
+// importBatch writes a batch of import rows. Updates go first, then the new products are inserted,
+// with COPY if there are enough of them. It returns ErrImportFailed if an atomic import can't
+// import every row of the batch.
+func (p ProductModel) importBatch(ctx context.Context, tx *sql.Tx, rows []*ImportRow, mode string) error {
+	err := checkImportCategories(ctx, tx, rows)
+	if err != nil {
+		return err
+	}
+
+	if mode == ImportAtomic && importHasErrors(rows) {
+		return ErrImportFailed
+	}
+
+	var inserts []*ImportRow
+	for _, row := range rows {
+		if len(row.Errors) > 0 {
+			continue
+		}
+
+		if row.Product.Id == "" {
+			inserts = append(inserts, row)
+			continue
+		}
+
+		product := row.Product
+		err := p.importRow(ctx, tx, row, mode, func() error { return importUpdate(ctx, tx, product) })
+		if err != nil {
+			return err
+		}
+	}
+
+	// COPY fails as a whole, so when it does the products are inserted one by one instead, to
+	// find the rows at fault.
+	if len(inserts) >= copyThreshold {
+		products := make([]*Products, len(inserts))
+		for i, row := range inserts {
+			products[i] = row.Product
+		}
+
+		copyErr, err := savepoint(ctx, tx, func() error { return copyProducts(ctx, tx, products) })
+		if err != nil {
+			return err
+		}
+		if copyErr == nil {
+			return nil
+		}
+	}
+
+	for _, row := range inserts {
+		product := row.Product
+		err := p.importRow(ctx, tx, row, mode, func() error { return insertProduct(ctx, tx, product) })
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// add reports on a row that has been through the import. failed tells whether the import has
+// failed, so a valid row wasn't imported.
+func (report *ImportReport) add(row *ImportRow, update, failed bool) {
+	result := &ImportResult{Line: row.Line, Errors: row.Errors}
+
+	switch {
+	case len(row.Errors) > 0:
+		result.Status = ImportFailed
+		report.Failed++
+	case failed:
+		result.Status = ImportSkipped
+		report.Skipped++
+	case update:
+		result.Status, result.ID = ImportUpdated, row.Product.Id
+		report.Updated++
+	default:
+		result.Status, result.ID = ImportCreated, row.Product.Id
+		report.Created++
+	}
+
+	report.Rows = append(report.Rows, result)
+}
+
+// skipImported marks the rows reported as imported as skipped, once an atomic import has failed
+// after them and they are rolled back.
+func (report *ImportReport) skipImported() {
+	for _, result := range report.Rows {
+		if result.Status == ImportCreated || result.Status == ImportUpdated {
+			result.Status, result.ID = ImportSkipped, ""
+		}
+	}
+
+	report.Skipped += report.Created + report.Updated
+	report.Created, report.Updated = 0, 0
+}
+
+// importRow writes a single row of an import with write. A row the database turns down is marked
+// as failed: an atomic import stops there, a best effort import rolls the row back to a savepoint
+// and goes on with the next one.
+func (p ProductModel) importRow(ctx context.Context, tx *sql.Tx, row *ImportRow, mode string, write func() error) error {
+	if mode == ImportAtomic {
+		err := write()
+		if err != nil && rejectImportRow(row, err) {
+			return ErrImportFailed
+		}
+		return err
+	}
+
+	rowErr, err := savepoint(ctx, tx, write)
+	if err != nil {
+		return err
+	}
+
+	if rowErr != nil && !rejectImportRow(row, rowErr) {
+		p.ErrorLog.Printf("import line %d: %v", row.Line, rowErr)
+		row.addError("row", "could not be saved")
+	}
+
+	return nil
+}
+
+// rejectImportRow marks the row as failed if err is the fault of the row, such as an ID that
+// doesn't exist or a value breaking a constraint, and tells whether it was.
+func rejectImportRow(row *ImportRow, err error) bool {
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, ErrRecordNotFound):
+		row.addError("id", "must be an existing product")
+	case errors.As(err, &pqErr) && pqErr.Code == "23503":
+		// The only foreign key of a product is its category.
+		row.addError("categoryId", "must be an existing category")
+	case errors.As(err, &pqErr) && pqErr.Code.Class() == "23":
+		row.addError("row", "breaks the "+pqErr.Constraint+" constraint")
+	default:
+		return false
+	}
+
+	return true
+}
+
+// savepoint runs fn within a savepoint of the transaction and rolls back to it if fn fails, so the
+// transaction can go on. It returns the error of fn, and err if the transaction can't go on.
+func savepoint(ctx context.Context, tx *sql.Tx, fn func() error) (fnErr, err error) {
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT import_row`); err != nil {
+		return nil, err
+	}
+
+	if fnErr := fn(); fnErr != nil {
+		_, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT import_row`)
+		return fnErr, err
+	}
+
+	_, err = tx.ExecContext(ctx, `RELEASE SAVEPOINT import_row`)
+	return nil, err
+}
+
+// importUpdate updates the product of an import row. The import replaces the product whatever
+// its current version, so the row is locked and its version read first.
+func importUpdate(ctx context.Context, tx *sql.Tx, product *Products) error {
+	query := `
+		SELECT version
+		FROM products
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+		`
+
+	err := tx.QueryRowContext(ctx, query, product.Id).Scan(&product.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return updateProduct(ctx, tx, product)
+}
+
+// copyProducts inserts the products with COPY and starts their price histories the same way.
+func copyProducts(ctx context.Context, tx *sql.Tx, products []*Products) error {
+	// Take the IDs from the sequence up front, so the rows don't have to be matched up with the
+	// products afterwards.
+	query := `SELECT nextval(pg_get_serial_sequence('products', 'id')) FROM generate_series(1, $1)`
+
+	rows, err := tx.QueryContext(ctx, query, len(products))
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int64, 0, len(products))
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for i, product := range products {
+		product.Id = strconv.FormatInt(ids[i], 10)
+	}
+
+	err = copyIn(ctx, tx, pq.CopyIn("products", "id", "title", "description", "countries", "price", "currency", "category_id"), len(products),
+		func(i int) []interface{} {
+			product := products[i]
+			return []interface{}{ids[i], product.Title, product.Description, pq.Array(product.Countries), product.Price.Amount, product.Price.Currency, product.CategoryID}
+		})
+	if err != nil {
+		return err
+	}
+
+	err = copyIn(ctx, tx, pq.CopyIn("product_prices", "product_id", "price", "currency"), len(products),
+		func(i int) []interface{} {
+			return []interface{}{ids[i], products[i].Price.Amount, products[i].Price.Currency}
+		})
+	if err != nil {
+		return err
+	}
+
+	query = `SELECT id, created_at, updated_at FROM products WHERE id = ANY($1)`
+
+	rows, err = tx.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byID := make(map[string]*Products, len(products))
+	for _, product := range products {
+		byID[product.Id] = product
+	}
+
+	for rows.Next() {
+		var id, createdAt, updatedAt string
+		if err := rows.Scan(&id, &createdAt, &updatedAt); err != nil {
+			return err
+		}
+		byID[id].CreatedAt, byID[id].UpdatedAt = createdAt, updatedAt
+	}
+
+	return rows.Err()
+}
+
+// copyIn runs a COPY statement for n rows, the values of each returned by row.
+func copyIn(ctx context.Context, tx *sql.Tx, statement string, n int, row func(i int) []interface{}) error {
+	stmt, err := tx.PrepareContext(ctx, statement)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		if _, err := stmt.ExecContext(ctx, row(i)...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+
+	// An Exec without arguments flushes the buffered rows.
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return err
+	}
+
+	return stmt.Close()
+}
+
+// checkImportCategories marks the rows whose category doesn't exist as failed. The categories
+// that do exist are locked, so they can't be deleted before the import is committed.
+func checkImportCategories(ctx context.Context, tx *sql.Tx, rows []*ImportRow) error {
+	var ids []int64
+	seen := make(map[int64]bool)
+	for _, row := range rows {
+		if row.Product.CategoryID != nil && !seen[*row.Product.CategoryID] {
+			seen[*row.Product.CategoryID] = true
+			ids = append(ids, *row.Product.CategoryID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	result, err := tx.QueryContext(ctx, `SELECT id FROM categories WHERE id = ANY($1) FOR KEY SHARE`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+
+	existing := make(map[int64]bool, len(ids))
+	for result.Next() {
+		var id int64
+		if err := result.Scan(&id); err != nil {
+			return err
+		}
+		existing[id] = true
+	}
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if row.Product.CategoryID != nil && !existing[*row.Product.CategoryID] {
+			row.addError("categoryId", "must be an existing category")
+		}
+	}
+
+	return nil
+}
+
+func importHasErrors(rows []*ImportRow) bool {
+	for _, row := range rows {
+		if len(row.Errors) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
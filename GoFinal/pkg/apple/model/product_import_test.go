@@ -0,0 +1,97 @@
+package model
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+// importRows returns a next function for an import of n valid rows, failing with err after them
+// if it isn't nil.
+func importRows(n int, err error) func() (*ImportRow, error) {
+	line := 1
+	return func() (*ImportRow, error) {
+		if line > n {
+			if err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		line++
+		return &ImportRow{Line: line, Product: &Products{}}, nil
+	}
+}
+
+func TestReadImportBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    int
+		batches []int
+	}{
+		{name: "empty", rows: 0, batches: []int{0}},
+		{name: "less than a batch", rows: 3, batches: []int{3}},
+		{name: "exactly a batch", rows: importBatchSize, batches: []int{importBatchSize, 0}},
+		{name: "more than a batch", rows: 2*importBatchSize + 1, batches: []int{importBatchSize, importBatchSize, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := importRows(tt.rows, nil)
+
+			for i, want := range tt.batches {
+				batch, done, err := readImportBatch(next)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(batch) != want {
+					t.Errorf("batch %d has %d rows, want %d", i, len(batch), want)
+				}
+				if last := i == len(tt.batches)-1; done != last {
+					t.Errorf("batch %d: done = %t, want %t", i, done, last)
+				}
+			}
+		})
+	}
+}
+
+func TestReadImportBatchError(t *testing.T) {
+	errRead := errors.New("unexpected EOF")
+
+	next := importRows(importBatchSize+1, errRead)
+	if _, _, err := readImportBatch(next); err != nil {
+		t.Fatalf("first batch: %v", err)
+	}
+	if _, _, err := readImportBatch(next); !errors.Is(err, errRead) {
+		t.Errorf("second batch: err = %v, want %v", err, errRead)
+	}
+}
+
+func TestImportReportSkipImported(t *testing.T) {
+	report := &ImportReport{Mode: ImportAtomic}
+
+	created := &ImportRow{Line: 2, Product: &Products{Id: "10"}}
+	updated := &ImportRow{Line: 3, Product: &Products{Id: "4"}}
+	invalid := &ImportRow{Line: 4, Product: &Products{}, Errors: map[string]string{"title": "must be provided"}}
+	after := &ImportRow{Line: 5, Product: &Products{}}
+
+	report.add(created, false, false)
+	report.add(updated, true, false)
+	report.add(invalid, false, true)
+	report.add(after, false, true)
+	report.skipImported()
+
+	want := []string{ImportSkipped, ImportSkipped, ImportFailed, ImportSkipped}
+	for i, result := range report.Rows {
+		if result.Status != want[i] {
+			t.Errorf("line %d: status %q, want %q", result.Line, result.Status, want[i])
+		}
+		if result.ID != "" {
+			t.Errorf("line %d: ID %q of a product that was rolled back", result.Line, result.ID)
+		}
+	}
+
+	if report.Created != 0 || report.Updated != 0 || report.Failed != 1 || report.Skipped != 3 {
+		t.Errorf("counts = %d created, %d updated, %d failed, %d skipped, want 0, 0, 1, 3",
+			report.Created, report.Updated, report.Failed, report.Skipped)
+	}
+}
@@ -0,0 +1,95 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ProductPrice is a single entry of the price history of a product. ValidTo is nil for the price
+// that is currently in effect.
+type ProductPrice struct {
+	Price     Money      `json:"price"`
+	ValidFrom time.Time  `json:"validFrom"`
+	ValidTo   *time.Time `json:"validTo"`
+}
+
+// recordPrice adds the current price of the product to its price history.
+func recordPrice(ctx context.Context, tx *sql.Tx, product *Products) error {
+	query := `
+		INSERT INTO product_prices (product_id, price, currency)
+		VALUES ($1, $2, $3)
+		`
+
+	_, err := tx.ExecContext(ctx, query, product.Id, product.Price.Amount, product.Price.Currency)
+	return err
+}
+
+// GetPrices returns the price history of a product, oldest price first.
+func (p ProductModel) GetPrices(id int) ([]*ProductPrice, error) {
+	query := `
+		SELECT price, currency, valid_from, LEAD(valid_from) OVER (ORDER BY valid_from, id)
+		FROM product_prices
+		WHERE product_id = $1
+		ORDER BY valid_from, id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			p.ErrorLog.Println(err)
+		}
+	}()
+
+	prices := []*ProductPrice{}
+	for rows.Next() {
+		var price ProductPrice
+		err := rows.Scan(&price.Price.Amount, &price.Price.Currency, &price.ValidFrom, &price.ValidTo)
+		if err != nil {
+			return nil, err
+		}
+
+		prices = append(prices, &price)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return prices, nil
+}
+
+// PriceAt returns the price of a product that was in effect at the given moment. It returns
+// ErrRecordNotFound if the product had no price yet at that moment.
+func (p ProductModel) PriceAt(id int, at time.Time) (Money, error) {
+	query := `
+		SELECT price, currency
+		FROM product_prices
+		WHERE product_id = $1 AND valid_from <= $2
+		ORDER BY valid_from DESC, id DESC
+		LIMIT 1
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var price Money
+	err := p.DB.QueryRowContext(ctx, query, id, at).Scan(&price.Amount, &price.Currency)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return Money{}, ErrRecordNotFound
+		default:
+			return Money{}, err
+		}
+	}
+
+	return price, nil
+}
@@ -3,20 +3,41 @@ package model
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+	"github.com/lib/pq"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Products struct {
-	Id             string `json:"id"`
-	CreatedAt      string `json:"createdAt"`
-	UpdatedAt      string `json:"updatedAt"`
-	Title          string `json:"title"`
-	Description    string `json:"description"`
-	ForWhatCountry string `json:"forWhatCountry"`
-	Price          uint   `json:"price"`
+	Id          string `json:"id"`
+	CreatedAt   string `json:"createdAt"`
+	UpdatedAt   string `json:"updatedAt"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// Countries holds the ISO 3166-1 alpha-2 codes and named regions (such as "EU") the product
+	// is made for.
+	Countries []string `json:"countries"`
+	Price     Money    `json:"price"`
+	// CategoryID is the category the product belongs to, if any.
+	CategoryID *int64 `json:"categoryId"`
+	// ConvertedPrice is the price converted into the currency the client asked for, if any.
+	ConvertedPrice *Money `json:"convertedPrice,omitempty"`
+	// Highlight holds the matched snippets when the product was found by a full-text search.
+	Highlight *Highlight `json:"highlight,omitempty"`
+	// Variants holds the variants of the product when a single product is returned.
+	Variants []*Variant `json:"variants,omitempty"`
+	// Images holds the images of the product when a single product is returned.
+	Images []*ProductImage `json:"images,omitempty"`
+	// Version goes up with every change of the product. It's sent as the ETag instead of in the
+	// body.
+	Version int `json:"-"`
+	// Stores holds the stores that carry the product when the client asked to include them.
+	Stores []*StoreProduct `json:"stores,omitempty"`
 }
 
 type ProductModel struct {
@@ -25,29 +46,83 @@ type ProductModel struct {
 	ErrorLog *log.Logger
 }
 
-func (p ProductModel) GetAll(title string, from, to int, filters Filters) ([]*Products, Metadata, error) {
+// ProductQuery holds the filters of the product list. The zero value of a field means the filter
+// is not applied.
+type ProductQuery struct {
+	Title     string
+	PriceFrom int
+	PriceTo   int
+	// PriceCurrency is the currency of PriceFrom and PriceTo. Prices are amounts in the minor
+	// units of their own currency, so only the products priced in it are compared with them.
+	PriceCurrency string
+	// Country is an ISO 3166-1 alpha-2 code or a named region. A product matches when it is
+	// made for the country itself or for a region containing it.
+	Country string
+	// Query is a full-text search over the title and description, in web search syntax
+	// (quoted phrases, "or" and -excluded words).
+	Query string
+	// Category restricts the list to a category and all of its descendants.
+	Category int64
+}
+
+// where returns the WHERE condition of the product list and its arguments. The condition uses
+// the placeholders $1 to $8, with the full-text search query always being $6, so a query can
+// append its own arguments after them.
+func (q ProductQuery) where() (string, []interface{}) {
+	where := fmt.Sprintf(`deleted_at IS NULL
+			AND (LOWER(title) = LOWER($1) OR $1 = '')
+			AND (price >= $2 OR $2 = 0)
+			AND (price <= $3 OR $3 = 0)
+			AND (countries && $4 OR $5 = '')
+			AND (search @@ websearch_to_tsquery('%s', $6) OR $6 = '')
+			AND (category_id IN (%s) OR $7 = 0)
+			AND (currency = $8 OR $8 = '')`, searchConfig, categorySubtreeSQL("$7"))
+
+	return where, []interface{}{q.Title, q.PriceFrom, q.PriceTo, pq.Array(coveringCodes(q.Country)), q.Country, q.Query, q.Category, q.PriceCurrency}
+}
+
+func (p ProductModel) GetAll(q ProductQuery, filters Filters) ([]*Products, Metadata, error) {
+	where, args := q.where()
+	whereArgs := args
+	keyset, keysetArgs := filters.keyset(len(args) + 1)
+	args = append(args, keysetArgs...)
+
+	// Retrieve all products items from the database. The page is selected in the inner query, so
+	// the costly highlighting is only done for the rows that are actually returned. The matching
+	// products are selected in a query of their own, so the page can be selected by their rank.
+	// Only the columns of the fields asked for are read, plus the ones the page is selected by.
+	columns := productColumns.selected(filters.Fields, "id", filters.sortKey())
 
-	// Retrieve all products items from the database.
 	query := fmt.Sprintf(
 		`
-		SELECT count(*) OVER(), id, created_at, updated_at, title, description, for_what_country, price
-		FROM products
-		WHERE (LOWER(title) = LOWER($1) OR $1 = '')
-		AND (price >= $2 OR $2 = 0)
-		AND (price <= $3 OR $3 = 0)
-		ORDER BY %s %s, id ASC
-		LIMIT $4 OFFSET $5
+		SELECT total, %[11]s, sort_key,
+			CASE WHEN $6 = '' THEN '' ELSE ts_headline('%[2]s', headline_title, websearch_to_tsquery('%[2]s', $6), '%[3]s') END,
+			CASE WHEN $6 = '' THEN '' ELSE ts_headline('%[2]s', coalesce(headline_description, ''), websearch_to_tsquery('%[2]s', $6), '%[4]s') END
+		FROM (
+			SELECT %[8]s AS total, %[11]s, search_rank,
+				%[9]s::text AS sort_key, title AS headline_title, description AS headline_description
+			FROM (
+				SELECT *,
+					CASE WHEN $6 = '' THEN 0 ELSE ts_rank(search, websearch_to_tsquery('%[2]s', $6)) END AS search_rank
+				FROM products
+				WHERE %[5]s
+			) AS matched
+			WHERE %[10]s
+			ORDER BY %[1]s
+			LIMIT $%[6]d OFFSET $%[7]d
+		) AS page
+		ORDER BY %[1]s
 		`,
-		filters.sortColumn(), filters.sortDirection())
+		filters.pageOrderBy(), searchConfig, titleHeadlineOptions, descriptionHeadlineOptions, where, len(args)+1, len(args)+2,
+		filters.total(), filters.sortKey(), keyset, selectList(columns))
 
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// Organize our four placeholder parameter values in a slice.
-	args := []interface{}{title, from, to, filters.limit(), filters.offset()}
+	// Organize our placeholder parameter values in a slice.
+	args = append(args, filters.limit(), filters.offset())
 
-	// log.Println(query, title, from, to, filters.limit(), filters.offset())
 	// Use QueryContext to execute the query. This returns a sql.Rows result set containing
 	// the result.
 	rows, err := p.DB.QueryContext(ctx, query, args...)
@@ -67,12 +142,22 @@ func (p ProductModel) GetAll(title string, from, to int, filters Filters) ([]*Pr
 	totalRecords := 0
 
 	var products []*Products
+	var keys []cursorKey
 	for rows.Next() {
 		var prod Products
-		err := rows.Scan(&totalRecords, &prod.Id, &prod.CreatedAt, &prod.UpdatedAt, &prod.Title, &prod.Description, &prod.ForWhatCountry, &prod.Price)
+		var highlight Highlight
+		var key cursorKey
+		dest := append([]interface{}{&totalRecords}, scanDest(columns, &prod)...)
+		err := rows.Scan(append(dest, &key.value, &highlight.Title, &highlight.Description)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+		key.id = prod.Id
+		keys = append(keys, key)
+
+		if q.Query != "" {
+			prod.Highlight = highlight.html()
+		}
 
 		// Add the Movie struct to the slice
 		products = append(products, &prod)
@@ -84,79 +169,204 @@ func (p ProductModel) GetAll(title string, from, to int, filters Filters) ([]*Pr
 		return nil, Metadata{}, err
 	}
 
+	if filters.Cursor != nil {
+		return cursorPage(filters, products, keys)
+	}
+
 	// Generate a Metadata struct, passing in the total record count and pagination parameters
 	// from the client.
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	metadata, err := pageMetadata(ctx, p.DB, "products", where, whereArgs, filters, totalRecords, len(products))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
 
 	// If everything went OK, then return the slice of the movies and metadata.
 	return products, metadata, nil
 }
 
-func (p ProductModel) Insert(product *Products) error {
-	query := `
-		INSERT INTO products (title, description, for_what_country, price) 
-		VALUES ($1, $2, $3, $4) 
-		RETURNING id, created_at, updated_at
-		`
-	args := []interface{}{product.Title, product.Description, product.ForWhatCountry, product.Price}
+// Insert creates the product and records it in the audit log with the given entry, if any.
+func (p ProductModel) Insert(product *Products, audit *AuditEntry) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return p.DB.QueryRowContext(ctx, query, args...).Scan(&product.Id, &product.CreatedAt, &product.UpdatedAt)
+	return withTx(ctx, p.DB, nil, func(tx *sql.Tx) error {
+		if err := insertProduct(ctx, tx, product); err != nil {
+			return err
+		}
+		return audit.record(ctx, tx, product.Id, product)
+	})
+}
+
+// insertProduct inserts the product and starts its price history within the transaction.
+func insertProduct(ctx context.Context, tx *sql.Tx, product *Products) error {
+	query := `
+		INSERT INTO products (title, description, countries, price, currency, category_id) 
+		VALUES ($1, $2, $3, $4, $5, $6) 
+		RETURNING id, created_at, updated_at, version
+		`
+	args := []interface{}{product.Title, product.Description, pq.Array(product.Countries), product.Price.Amount, product.Price.Currency, product.CategoryID}
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&product.Id, &product.CreatedAt, &product.UpdatedAt, &product.Version)
+	if err != nil {
+		return err
+	}
+
+	return recordPrice(ctx, tx, product)
 }
 
 func (p ProductModel) Get(id int) (*Products, error) {
+	return p.GetFields(id, nil)
+}
+
+// GetFields returns the product with only the given fields read, or all of them if fields is
+// empty. The ID is always read.
+func (p ProductModel) GetFields(id int, fields []string) (*Products, error) {
 	// Return an error if the ID is less than 1.
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
+	columns := productColumns.selected(fields, "id", "version")
+
 	query := `
-		SELECT id, created_at, updated_at, title, description, for_what_country, price
+		SELECT ` + selectList(columns) + `
 		FROM products
-		WHERE id = $1
+		WHERE id = $1 AND deleted_at IS NULL
 		`
 	var product Products
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	row := p.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&product.Id, &product.CreatedAt, &product.UpdatedAt, &product.Title, &product.Description, &product.ForWhatCountry, &product.Price)
+	err := row.Scan(scanDest(columns, &product)...)
 	if err != nil {
-		return nil, fmt.Errorf("cannot retrive product with id: %v, %w", id, err)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, fmt.Errorf("cannot retrive product with id: %v, %w", id, err)
+		}
 	}
 	return &product, nil
 }
 
-func (p ProductModel) Update(product *Products) error {
+// Update saves the product and records the change in the audit log with the given entry, if any.
+func (p ProductModel) Update(product *Products, audit *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, p.DB, nil, func(tx *sql.Tx) error {
+		if err := updateProduct(ctx, tx, product); err != nil {
+			return err
+		}
+		return audit.record(ctx, tx, product.Id, product)
+	})
+}
+
+// updateProduct updates the product within the transaction and adds the new price to the price
+// history if it has changed. It returns ErrEditConflict if the product is no longer at the version
+// it was read at, and ErrRecordNotFound if it was deleted in the meantime.
+func updateProduct(ctx context.Context, tx *sql.Tx, product *Products) error {
+	// The old price is read from a locked copy of the row, so a concurrent update can't slip in
+	// between reading it and writing the new one.
 	query := `
 		UPDATE products
-		SET title = $1, description = $2, for_what_country = $3, price = $4, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $5 AND updated_at = $6
-		RETURNING updated_at
+		SET title = $1, description = $2, countries = $3, price = $4, currency = $5, category_id = $8, updated_at = CURRENT_TIMESTAMP,
+			version = products.version + 1
+		FROM (SELECT price, currency FROM products WHERE id = $6 FOR UPDATE) AS old
+		WHERE id = $6 AND version = $7 AND deleted_at IS NULL
+		RETURNING products.updated_at, products.version, old.price, old.currency
 		`
-	args := []interface{}{product.Title, product.Description, product.ForWhatCountry, product.Price, product.Id, product.UpdatedAt}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args := []interface{}{product.Title, product.Description, pq.Array(product.Countries), product.Price.Amount, product.Price.Currency, product.Id, product.Version, product.CategoryID}
+
+	var oldPrice Money
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&product.UpdatedAt, &product.Version, &oldPrice.Amount, &oldPrice.Currency)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return versionMismatch(ctx, tx, "products", product.Id)
+		default:
+			return err
+		}
+	}
 
-	return p.DB.QueryRowContext(ctx, query, args...).Scan(&product.UpdatedAt)
+	if oldPrice == product.Price {
+		return nil
+	}
+
+	return recordPrice(ctx, tx, product)
 }
 
-func (p ProductModel) Delete(id int) error {
+// Delete moves the product to the trash. It's hidden from then on, but kept together with its
+// stock, variants and images until it's restored or purged. It returns ErrEditConflict if the
+// product is no longer at the given version, and ErrRecordNotFound if it's gone or already in the
+// trash. The deletion is recorded in the audit log with the given entry, if any.
+func (p ProductModel) Delete(id, version int, audit *AuditEntry) error {
 	// Return an error if the ID is less than 1.
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-		DELETE FROM products
-		WHERE id = $1
+		UPDATE products
+		SET deleted_at = CURRENT_TIMESTAMP, version = version + 1
+		WHERE id = $1 AND version = $2 AND deleted_at IS NULL
 		`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := p.DB.ExecContext(ctx, query, id)
-	return err
+	return withTx(ctx, p.DB, nil, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, id, version)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return versionMismatch(ctx, tx, "products", id)
+		}
+
+		return audit.record(ctx, tx, strconv.Itoa(id), nil)
+	})
+}
+
+// Restore takes the product out of the trash. It returns ErrRecordNotFound if the product is not
+// in the trash. The restore is recorded in the audit log with the given entry, if any.
+func (p ProductModel) Restore(id int, audit *AuditEntry) (*Products, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE products
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING ` + selectList(productColumns.columns) + `
+		`
+	var product Products
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := withTx(ctx, p.DB, nil, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, id).Scan(scanDest(productColumns.columns, &product)...)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+		return audit.record(ctx, tx, product.Id, &product)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
 }
 
 func ValidateProduct(v *validator.Validator, prod *Products) {
@@ -166,8 +376,29 @@ func ValidateProduct(v *validator.Validator, prod *Products) {
 	v.Check(len(prod.Title) <= 100, "title", "must not be more than 100 bytes long")
 	// Check if the description field is not more than 1000 characters.
 	v.Check(len(prod.Description) <= 1000, "description", "must not be more than 1000 bytes long")
-	// Check if the forWhatCountry is not more than 10 characters.
-	v.Check(len(prod.ForWhatCountry) <= 10, "forWhatCountry", "must not be more than 10 bytes long")
-	// Check if the nutrition value is not more than 10000.
-	v.Check(prod.Price <= 10000, "price", "must not be more than 1000")
+	// Check if the countries are known ISO 3166-1 alpha-2 codes or regions.
+	ValidateCountries(v, "countries", prod.Countries)
+	// Check if the price has a supported currency and is not more than 1 million major units.
+	ValidateMoney(v, "price", prod.Price, 1_000_000)
+}
+
+// ValidateProductQuery runs validation checks on the product list filters.
+func ValidateProductQuery(v *validator.Validator, q ProductQuery) {
+	if q.Country != "" {
+		v.Check(IsCountry(q.Country) || IsRegion(q.Country), "country", "must be an ISO 3166-1 alpha-2 country code or a region")
+	}
+
+	v.Check(q.Category >= 0, "category", "must be a category ID")
+
+	v.Check(q.PriceCurrency != "" || (q.PriceFrom == 0 && q.PriceTo == 0), "priceCurrency", "must be given together with priceFrom and priceTo")
+	if q.PriceCurrency != "" {
+		v.Check(IsCurrency(q.PriceCurrency), "priceCurrency", "must be a supported ISO 4217 currency code")
+	}
+}
+
+// ValidatePriceSort checks that a product listing is only sorted by price within a single
+// currency. Comparing amounts in the minor units of different currencies would put 1000 JPY
+// next to 10.00 USD.
+func ValidatePriceSort(v *validator.Validator, q ProductQuery, f Filters) {
+	v.Check(q.PriceCurrency != "" || strings.TrimPrefix(f.Sort, "-") != "price", "sort", "price can only be used together with priceCurrency")
 }
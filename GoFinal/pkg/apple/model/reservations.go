@@ -0,0 +1,214 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// Reservation statuses. Only active reservations hold stock, the other ones are history.
+const (
+	ReservationActive   = "active"
+	ReservationReleased = "released"
+	ReservationExpired  = "expired"
+)
+
+var (
+	// ErrInsufficientStock is returned when a store doesn't have enough items left to reserve.
+	ErrInsufficientStock = errors.New("insufficient stock")
+
+	// ErrReservationNotActive is returned when releasing a reservation that was already released
+	// or has expired.
+	ErrReservationNotActive = errors.New("reservation is not active")
+)
+
+// Reservation holds a quantity of a product, or of one variant of it, in a store for a user until
+// it expires. While the reservation is active the quantity is taken out of the store stock.
+type Reservation struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	StoreID   int64     `json:"storeId"`
+	ProductID int64     `json:"productId"`
+	VariantID *int64    `json:"variantId"`
+	UserID    int64     `json:"userId"`
+	Quantity  int       `json:"quantity"`
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type ReservationModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert reserves the quantity of the product in the store. The stock row is locked with
+// SELECT ... FOR UPDATE for the duration of the transaction, so concurrent reservations for the
+// same product are serialized and the stock can never drop below zero. It returns
+// ErrRecordNotFound if the store doesn't carry the product and ErrInsufficientStock if there is
+// not enough of it left.
+func (m ReservationModel) Insert(reservation *Reservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		var available int
+		err := tx.QueryRowContext(ctx, `
+			SELECT quantity
+			FROM stores_and_products
+			WHERE store = $1 AND product = $2 AND coalesce(variant_id, 0) = $3
+				AND store IN (SELECT id FROM stores WHERE deleted_at IS NULL)
+				AND product IN (SELECT id FROM products WHERE deleted_at IS NULL)
+			FOR UPDATE
+			`, reservation.StoreID, reservation.ProductID, variantKey(reservation.VariantID)).Scan(&available)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		if available < reservation.Quantity {
+			return ErrInsufficientStock
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE stores_and_products
+			SET quantity = quantity - $4, updated_at = CURRENT_TIMESTAMP
+			WHERE store = $1 AND product = $2 AND coalesce(variant_id, 0) = $3
+			`, reservation.StoreID, reservation.ProductID, variantKey(reservation.VariantID), reservation.Quantity)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO reservations (store_id, product_id, variant_id, user_id, quantity, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, created_at, status
+			`
+		args := []interface{}{reservation.StoreID, reservation.ProductID, reservation.VariantID, reservation.UserID, reservation.Quantity, reservation.ExpiresAt}
+
+		return tx.QueryRowContext(ctx, query, args...).Scan(&reservation.ID, &reservation.CreatedAt, &reservation.Status)
+	})
+}
+
+// GetForUser returns a reservation of a store made by the given user.
+func (m ReservationModel) GetForUser(id, storeID, userID int64) (*Reservation, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, store_id, product_id, variant_id, user_id, quantity, status, expires_at
+		FROM reservations
+		WHERE id = $1 AND store_id = $2 AND user_id = $3
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var r Reservation
+	err := m.DB.QueryRowContext(ctx, query, id, storeID, userID).Scan(
+		&r.ID, &r.CreatedAt, &r.StoreID, &r.ProductID, &r.VariantID, &r.UserID, &r.Quantity, &r.Status, &r.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &r, nil
+}
+
+// Release gives the reserved quantity back to the store stock. It returns ErrReservationNotActive
+// if the reservation was already released or has expired in the meantime.
+func (m ReservationModel) Release(reservation *Reservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		// Lock the reservation first, so a concurrent release or the expiry sweep can't return
+		// the same quantity twice.
+		err := tx.QueryRowContext(ctx, `
+			SELECT status
+			FROM reservations
+			WHERE id = $1
+			FOR UPDATE
+			`, reservation.ID).Scan(&reservation.Status)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		if reservation.Status != ReservationActive {
+			return ErrReservationNotActive
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE stores_and_products
+			SET quantity = quantity + $4, updated_at = CURRENT_TIMESTAMP
+			WHERE store = $1 AND product = $2 AND coalesce(variant_id, 0) = $3
+			`, reservation.StoreID, reservation.ProductID, variantKey(reservation.VariantID), reservation.Quantity)
+		if err != nil {
+			return err
+		}
+
+		return tx.QueryRowContext(ctx, `
+			UPDATE reservations
+			SET status = $2, released_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+			RETURNING status
+			`, reservation.ID, ReservationReleased).Scan(&reservation.Status)
+	})
+}
+
+// ReleaseExpired marks every active reservation past its expiry as expired and returns the
+// reserved quantities to the store stock in one statement. It returns the number of stock rows
+// that were replenished.
+func (m ReservationModel) ReleaseExpired() (int64, error) {
+	query := `
+		WITH expired AS (
+			UPDATE reservations
+			SET status = $1, released_at = CURRENT_TIMESTAMP
+			WHERE status = $2 AND expires_at <= CURRENT_TIMESTAMP
+			RETURNING store_id, product_id, coalesce(variant_id, 0) AS variant_id, quantity
+		), totals AS (
+			SELECT store_id, product_id, variant_id, sum(quantity) AS quantity
+			FROM expired
+			GROUP BY store_id, product_id, variant_id
+		)
+		UPDATE stores_and_products sp
+		SET quantity = sp.quantity + totals.quantity, updated_at = CURRENT_TIMESTAMP
+		FROM totals
+		WHERE sp.store = totals.store_id AND sp.product = totals.product_id AND coalesce(sp.variant_id, 0) = totals.variant_id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, ReservationExpired, ReservationActive)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func ValidateReservation(v *validator.Validator, reservation *Reservation) {
+	v.Check(reservation.ProductID > 0, "productId", "must be provided")
+	v.Check(reservation.VariantID == nil || *reservation.VariantID > 0, "variantId", "must be a variant ID")
+	v.Check(reservation.Quantity > 0, "quantity", "must be greater than 0")
+	v.Check(reservation.Quantity <= 100, "quantity", "must not be more than 100")
+}
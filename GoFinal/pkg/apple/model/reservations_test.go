@@ -0,0 +1,102 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestReservationInsertConcurrent fires more reservations at a stock row than it holds, all at
+// once, and checks that exactly as many go through as there are items and the stock ends at zero
+// instead of below it.
+func TestReservationInsertConcurrent(t *testing.T) {
+	db := testDB(t)
+	models := NewModels(db)
+
+	const stock, attempts = 10, 40
+
+	user := &User{Name: "Reservation test", Email: fmt.Sprintf("reservations-%d@example.com", time.Now().UnixNano())}
+	user.Password.hash = []byte("not a real hash")
+	if err := models.Users.Insert(user, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mustExec(t, db, `DELETE FROM users WHERE id = $1`, user.ID) })
+
+	store := &Store{Title: "Reservation test", Description: "Concurrent reservations", Address: "Abai 1"}
+	if err := models.Stores.Insert(store, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mustExec(t, db, `DELETE FROM stores WHERE id = $1`, store.Id) })
+
+	product := &Products{Title: "Reservation test", Description: "Concurrent reservations", Countries: []string{"KZ"}, Price: Money{Amount: 100, Currency: "USD"}}
+	if err := models.Products.Insert(product, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mustExec(t, db, `DELETE FROM products WHERE id = $1`, product.Id) })
+
+	if err := models.StoreProducts.Upsert(&StoreProduct{StoreId: store.Id, ProductId: product.Id, Quantity: stock}); err != nil {
+		t.Fatal(err)
+	}
+
+	storeID, _ := strconv.ParseInt(store.Id, 10, 64)
+	productID, _ := strconv.ParseInt(product.Id, 10, 64)
+
+	var (
+		wg           sync.WaitGroup
+		mu           sync.Mutex
+		reserved     int
+		insufficient int
+		unexpected   []error
+	)
+
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			err := models.Reservations.Insert(&Reservation{
+				StoreID:   storeID,
+				ProductID: productID,
+				UserID:    user.ID,
+				Quantity:  1,
+				ExpiresAt: time.Now().Add(time.Hour),
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				reserved++
+			case errors.Is(err, ErrInsufficientStock):
+				insufficient++
+			default:
+				unexpected = append(unexpected, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for _, err := range unexpected {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if reserved != stock {
+		t.Errorf("got %d reservations, want %d", reserved, stock)
+	}
+	if insufficient != attempts-stock {
+		t.Errorf("got %d insufficient stock errors, want %d", insufficient, attempts-stock)
+	}
+
+	sp, err := models.StoreProducts.Get(int(storeID), int(productID), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.Quantity != 0 {
+		t.Errorf("got quantity %d left, want 0", sp.Quantity)
+	}
+}
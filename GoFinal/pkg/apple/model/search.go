@@ -0,0 +1,138 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"html"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// SortRelevance is the sort value that orders full-text search results by their rank, best
+// matches first.
+const SortRelevance = "relevance"
+
+// searchConfig is the text search configuration the search columns are built with. The queries
+// have to use the same one, otherwise the GIN indexes are not used.
+const searchConfig = "english"
+
+// ts_headline marks the matched words with these private use characters rather than with the
+// <mark> tags themselves, so the text around them can be HTML-escaped before they are turned into
+// tags.
+const (
+	headlineStart = "\uE000"
+	headlineStop  = "\uE001"
+)
+
+// headlineOptions are the ts_headline options of the highlighted description snippets. The title
+// is short, so it's highlighted as a whole.
+const (
+	titleHeadlineOptions       = "HighlightAll=true, StartSel=" + headlineStart + ", StopSel=" + headlineStop
+	descriptionHeadlineOptions = "StartSel=" + headlineStart + ", StopSel=" + headlineStop + ", MaxFragments=2, MaxWords=25, MinWords=8, FragmentDelimiter=\" ... \""
+)
+
+var headlineMarks = strings.NewReplacer(headlineStart, "<mark>", headlineStop, "</mark>")
+
+// Highlight holds the snippets of a full-text search result as HTML: the text is escaped and the
+// matched words are wrapped in <mark></mark>, so it can be put into a page as it is.
+type Highlight struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// html returns the highlight of the ts_headline snippets, escaped and with the marks turned into
+// tags. The titles and descriptions are plain text that may well hold a "<", by accident or not.
+func (h Highlight) html() *Highlight {
+	return &Highlight{
+		Title:       headlineMarks.Replace(html.EscapeString(h.Title)),
+		Description: headlineMarks.Replace(html.EscapeString(h.Description)),
+	}
+}
+
+// ValidateSearch checks the full-text search query of a listing, and that it is only sorted by
+// relevance when there is a query to rank the results against.
+func ValidateSearch(v *validator.Validator, query string, f Filters) {
+	v.Check(len(query) <= 200, "q", "must not be more than 200 bytes long")
+	v.Check(f.Sort != SortRelevance || query != "", "sort", "relevance can only be used together with q")
+}
+
+// Suggestion is a product or store title offered while the user is typing a search query.
+type Suggestion struct {
+	Type  string  `json:"type"` // "product" or "store"
+	ID    int64   `json:"id"`
+	Title string  `json:"title"`
+	Score float64 `json:"score"`
+}
+
+type SearchModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Suggest returns up to limit product and store titles that look like q, best matches first. The
+// titles are compared by trigram word similarity, so a typo ("macbok") or the beginning of a word
+// ("macb") still finds "MacBook Pro".
+func (m SearchModel) Suggest(q string, limit int) ([]*Suggestion, error) {
+	// Each side is limited on its own first, so the trigram indexes do the heavy lifting.
+	query := `
+		SELECT type, id, title, score
+		FROM (
+			(SELECT 'product' AS type, id, title, word_similarity($1, title) AS score
+			FROM products
+			WHERE $1 <% title AND deleted_at IS NULL
+			ORDER BY score DESC, id ASC
+			LIMIT $2)
+			UNION ALL
+			(SELECT 'store' AS type, id, title, word_similarity($1, title) AS score
+			FROM stores
+			WHERE $1 <% title AND deleted_at IS NULL
+			ORDER BY score DESC, id ASC
+			LIMIT $2)
+		) AS suggestions
+		ORDER BY score DESC, title ASC
+		LIMIT $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, q, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	suggestions := []*Suggestion{}
+	for rows.Next() {
+		var s Suggestion
+		if err := rows.Scan(&s.Type, &s.ID, &s.Title, &s.Score); err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+func ValidateSuggestQuery(v *validator.Validator, q string, limit int) {
+	v.Check(q != "", "q", "must be provided")
+	v.Check(q == "" || utf8.RuneCountInString(q) >= 2, "q", "must be at least 2 characters long")
+	v.Check(len(q) <= 100, "q", "must not be more than 100 bytes long")
+
+	v.Check(limit > 0, "limit", "must be greater than 0")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+}
@@ -0,0 +1,36 @@
+package model
+
+import "testing"
+
+func TestHighlightHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "marks",
+			in:   "Apple " + headlineStart + "iPhone" + headlineStop + " 15",
+			want: "Apple <mark>iPhone</mark> 15",
+		},
+		{
+			name: "markup in the text",
+			in:   `<img src=x onerror="alert(1)"> ` + headlineStart + "iPhone" + headlineStop,
+			want: `&lt;img src=x onerror=&#34;alert(1)&#34;&gt; <mark>iPhone</mark>`,
+		},
+		{
+			name: "entities in the text",
+			in:   "AT&amp;T " + headlineStart + "case" + headlineStop,
+			want: "AT&amp;amp;T <mark>case</mark>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Highlight{Title: tt.in, Description: tt.in}.html()
+			if got.Title != tt.want || got.Description != tt.want {
+				t.Errorf("got %q and %q, want %q", got.Title, got.Description, tt.want)
+			}
+		})
+	}
+}
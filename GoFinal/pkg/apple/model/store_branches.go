@@ -0,0 +1,235 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// phoneRX loosely matches a phone number: digits with an optional leading plus, spaces, dashes and
+// parentheses.
+var phoneRX = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{3,23}[0-9]$`)
+
+// Branch is a location of a store. The number of branches of a store is counted from these.
+type Branch struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	StoreID   int64     `json:"storeId"`
+	Address   string    `json:"address"`
+	// Latitude and Longitude are the WGS 84 location of the branch in degrees, both or neither.
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Phone     string   `json:"phone"`
+	// Hours are the weekly opening periods of the branch, in the time zone of the store.
+	Hours []OpeningPeriod `json:"hours"`
+}
+
+type BranchModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+const branchColumns = `id, created_at, updated_at, store_id, address, latitude, longitude, phone, hours`
+
+func scanBranch(row interface{ Scan(...interface{}) error }) (*Branch, error) {
+	var branch Branch
+	var hours []byte
+
+	err := row.Scan(&branch.ID, &branch.CreatedAt, &branch.UpdatedAt, &branch.StoreID, &branch.Address,
+		&branch.Latitude, &branch.Longitude, &branch.Phone, &hours)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(hours, &branch.Hours); err != nil {
+		return nil, err
+	}
+
+	return &branch, nil
+}
+
+// GetAllForStore returns every branch of the store ordered by ID.
+func (m BranchModel) GetAllForStore(storeID int64) ([]*Branch, error) {
+	query := `
+		SELECT ` + branchColumns + `
+		FROM store_branches
+		WHERE store_id = $1
+		ORDER BY id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, storeID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	branches := []*Branch{}
+	for rows.Next() {
+		branch, err := scanBranch(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		branches = append(branches, branch)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return branches, nil
+}
+
+// Get returns a branch of the store.
+func (m BranchModel) Get(storeID, id int64) (*Branch, error) {
+	if storeID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + branchColumns + `
+		FROM store_branches
+		WHERE store_id = $1 AND id = $2
+			AND store_id IN (SELECT id FROM stores WHERE deleted_at IS NULL)
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	branch, err := scanBranch(m.DB.QueryRowContext(ctx, query, storeID, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return branch, nil
+}
+
+// Insert adds a branch to its store.
+func (m BranchModel) Insert(branch *Branch) error {
+	hours, err := json.Marshal(branch.hours())
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO store_branches (store_id, address, latitude, longitude, phone, hours)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at
+		`
+	args := []interface{}{branch.StoreID, branch.Address, branch.Latitude, branch.Longitude, branch.Phone, hours}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&branch.ID, &branch.CreatedAt, &branch.UpdatedAt)
+	if err != nil && err.Error() == `pq: insert or update on table "store_branches" violates foreign key constraint "store_branches_store_id_fkey"` {
+		return ErrRecordNotFound
+	}
+
+	return err
+}
+
+// Update saves the address, location, phone and hours of the branch.
+func (m BranchModel) Update(branch *Branch) error {
+	hours, err := json.Marshal(branch.hours())
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE store_branches
+		SET address = $1, latitude = $2, longitude = $3, phone = $4, hours = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE store_id = $6 AND id = $7
+		RETURNING updated_at
+		`
+	args := []interface{}{branch.Address, branch.Latitude, branch.Longitude, branch.Phone, hours, branch.StoreID, branch.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&branch.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+
+	return err
+}
+
+// Delete removes a branch of the store.
+func (m BranchModel) Delete(storeID, id int64) error {
+	if storeID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM store_branches
+		WHERE store_id = $1 AND id = $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, storeID, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// hours returns the opening periods of the branch, never nil so they are stored as a JSON array.
+func (branch *Branch) hours() []OpeningPeriod {
+	if branch.Hours == nil {
+		return []OpeningPeriod{}
+	}
+
+	return branch.Hours
+}
+
+func ValidateBranch(v *validator.Validator, branch *Branch) {
+	v.Check(branch.Address != "", "address", "must be provided")
+	v.Check(len(branch.Address) <= 500, "address", "must not be more than 500 bytes long")
+
+	v.Check((branch.Latitude == nil) == (branch.Longitude == nil), "latitude", "must be provided together with longitude")
+	if branch.Latitude != nil {
+		ValidateLatitude(v, "latitude", *branch.Latitude)
+	}
+	if branch.Longitude != nil {
+		ValidateLongitude(v, "longitude", *branch.Longitude)
+	}
+
+	if branch.Phone != "" {
+		v.Check(validator.Matches(branch.Phone, phoneRX), "phone", "must be a valid phone number")
+	}
+
+	validateWeekly(v, "hours", branch.Hours)
+}
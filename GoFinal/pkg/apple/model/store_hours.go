@@ -0,0 +1,520 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	// Embed the time zone database, the runtime image doesn't ship one.
+	_ "time/tzdata"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	minutesPerDay  = 24 * 60
+	minutesPerWeek = 7 * minutesPerDay
+
+	// dateLayout is the layout of the closure dates.
+	dateLayout = "2006-01-02"
+
+	// scheduleHorizonDays is how far ahead the next opening of a store is looked for.
+	scheduleHorizonDays = 366
+)
+
+var weekdayNames = [...]string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+// Weekday is a day of the week, 0 is Sunday. It is written in JSON by its English name.
+type Weekday time.Weekday
+
+func (d Weekday) MarshalJSON() ([]byte, error) {
+	if d < 0 || int(d) >= len(weekdayNames) {
+		return nil, fmt.Errorf("invalid weekday %d", d)
+	}
+
+	return json.Marshal(weekdayNames[d])
+}
+
+func (d *Weekday) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return errors.New("weekday must be a string such as \"monday\"")
+	}
+
+	for i, weekdayName := range weekdayNames {
+		if strings.EqualFold(name, weekdayName) {
+			*d = Weekday(i)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown weekday %q", name)
+}
+
+var clockTimeRX = regexp.MustCompile(`^([01][0-9]|2[0-4]):([0-5][0-9])$`)
+
+// ClockTime is a wall-clock time of the day in minutes since midnight, written in JSON as
+// "HH:MM". "24:00" is the end of the day.
+type ClockTime int
+
+func (c ClockTime) String() string {
+	return fmt.Sprintf("%02d:%02d", c/60, c%60)
+}
+
+func (c ClockTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+func (c *ClockTime) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("time must be a string such as \"09:30\"")
+	}
+
+	m := clockTimeRX.FindStringSubmatch(s)
+	if m == nil {
+		return fmt.Errorf("invalid time %q, must be HH:MM", s)
+	}
+
+	hours, _ := strconv.Atoi(m[1])
+	minutes, _ := strconv.Atoi(m[2])
+
+	*c = ClockTime(hours*60 + minutes)
+	if *c > minutesPerDay {
+		return fmt.Errorf("invalid time %q, must not be after 24:00", s)
+	}
+
+	return nil
+}
+
+// OpeningPeriod is a period the store is open every week. It belongs to the weekday it opens on,
+// a period that closes at or before the time it opens runs past midnight.
+type OpeningPeriod struct {
+	Weekday Weekday   `json:"weekday"`
+	Opens   ClockTime `json:"opens"`
+	Closes  ClockTime `json:"closes"`
+}
+
+// minutes returns the length of the period in wall-clock minutes.
+func (p OpeningPeriod) minutes() int {
+	length := int(p.Closes - p.Opens)
+	if length <= 0 {
+		length += minutesPerDay
+	}
+
+	return length
+}
+
+// Closure is a range of days, e.g. holidays, the store doesn't open on. Both ends are included.
+type Closure struct {
+	ID        int64  `json:"id"`
+	CreatedAt string `json:"createdAt"`
+	StoreID   int64  `json:"-"`
+	StartsOn  string `json:"startsOn"`
+	EndsOn    string `json:"endsOn"`
+	Reason    string `json:"reason"`
+}
+
+// StoreHours holds the weekly opening hours and the special closures of a store. The hours are
+// wall-clock times in the time zone of the store.
+type StoreHours struct {
+	TimeZone string          `json:"timeZone"`
+	Weekly   []OpeningPeriod `json:"weekly"`
+	Closures []*Closure      `json:"closures"`
+}
+
+// Schedule tells when a store is open.
+type Schedule struct {
+	location *time.Location
+	weekly   [7][]OpeningPeriod
+	// closures holds the closed days as UTC midnights.
+	closures [][2]time.Time
+}
+
+// NewSchedule builds the schedule of the store hours.
+func NewSchedule(hours *StoreHours) (*Schedule, error) {
+	location, err := time.LoadLocation(hours.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Schedule{location: location}
+
+	for _, period := range hours.Weekly {
+		s.weekly[period.Weekday] = append(s.weekly[period.Weekday], period)
+	}
+	for _, periods := range s.weekly {
+		sort.Slice(periods, func(i, j int) bool { return periods[i].Opens < periods[j].Opens })
+	}
+
+	for _, closure := range hours.Closures {
+		startsOn, err := time.Parse(dateLayout, closure.StartsOn)
+		if err != nil {
+			return nil, err
+		}
+		endsOn, err := time.Parse(dateLayout, closure.EndsOn)
+		if err != nil {
+			return nil, err
+		}
+		s.closures = append(s.closures, [2]time.Time{startsOn, endsOn})
+	}
+
+	return s, nil
+}
+
+// OpenAt reports whether the store is open at t.
+func (s *Schedule) OpenAt(t time.Time) bool {
+	today := s.date(t)
+
+	// A period is at most a day long, so only the periods that opened yesterday or today can
+	// still be running.
+	for _, day := range []time.Time{today.AddDate(0, 0, -1), today} {
+		for _, span := range s.spans(day) {
+			if !t.Before(span[0]) && t.Before(span[1]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// NextOpening returns the first time after t the store opens, or nil if it doesn't open within
+// a year.
+func (s *Schedule) NextOpening(t time.Time) *time.Time {
+	today := s.date(t)
+
+	for i := 0; i <= scheduleHorizonDays; i++ {
+		for _, span := range s.spans(today.AddDate(0, 0, i)) {
+			if span[0].After(t) {
+				return &span[0]
+			}
+		}
+	}
+
+	return nil
+}
+
+// date returns the day t falls on in the time zone of the store, as a UTC midnight.
+func (s *Schedule) date(t time.Time) time.Time {
+	year, month, day := t.In(s.location).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// spans returns the opening and closing instants of the periods that open on the day. The
+// periods are laid out on the wall clock of the store, time.Date takes care of the daylight
+// saving time changes: a day can be 23 or 25 hours long, and a period that opens in a skipped
+// hour opens when the clock has been put forward.
+func (s *Schedule) spans(day time.Time) [][2]time.Time {
+	for _, closure := range s.closures {
+		if !day.Before(closure[0]) && !day.After(closure[1]) {
+			return nil
+		}
+	}
+
+	year, month, dayOfMonth := day.Date()
+
+	var spans [][2]time.Time
+	for _, period := range s.weekly[day.Weekday()] {
+		opens := time.Date(year, month, dayOfMonth, 0, int(period.Opens), 0, 0, s.location)
+		closes := time.Date(year, month, dayOfMonth, 0, int(period.Opens)+period.minutes(), 0, 0, s.location)
+		spans = append(spans, [2]time.Time{opens, closes})
+	}
+
+	return spans
+}
+
+// storeOpenSQL is the SQL counterpart of Schedule.OpenAt. It returns a condition that is true when
+// the store "s" is open at the timestamptz in the placeholder. It has to agree with OpenAt, or the
+// openNow of a store would contradict the open_now filter: timezone() takes a wall-clock time in
+// the hour skipped by a daylight saving time change at the offset from before the change, and one
+// in the repeated hour at the offset from after it, the same as time.Date. The DST tests check
+// both on the same instants.
+func storeOpenSQL(at string) string {
+	return fmt.Sprintf(`EXISTS (
+			SELECT 1
+			FROM store_hours h
+				CROSS JOIN LATERAL (VALUES (timezone(s.time_zone, %[1]s)::date), (timezone(s.time_zone, %[1]s)::date - 1)) AS d(day)
+			WHERE h.store_id = s.id
+				AND h.weekday = extract(dow FROM d.day)
+				AND %[1]s >= timezone(s.time_zone, d.day + make_interval(mins => h.opens_at))
+				AND %[1]s < timezone(s.time_zone, d.day + make_interval(mins => CASE WHEN h.closes_at > h.opens_at THEN h.closes_at ELSE h.closes_at + %[2]d END))
+				AND NOT EXISTS (SELECT 1 FROM store_closures c WHERE c.store_id = s.id AND d.day BETWEEN c.starts_on AND c.ends_on)
+		)`, at, minutesPerDay)
+}
+
+type StoreHoursModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Get returns the time zone, weekly hours and closures of the store.
+func (m StoreHoursModel) Get(storeID int64) (*StoreHours, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var hours StoreHours
+	err := m.DB.QueryRowContext(ctx, `SELECT time_zone FROM stores WHERE id = $1 AND deleted_at IS NULL`, storeID).Scan(&hours.TimeZone)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	all, err := m.load(ctx, []int64{storeID}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	hours.Weekly = []OpeningPeriod{}
+	hours.Closures = []*Closure{}
+	if h, ok := all[storeID]; ok {
+		hours.Weekly = append(hours.Weekly, h.Weekly...)
+		hours.Closures = append(hours.Closures, h.Closures...)
+	}
+
+	return &hours, nil
+}
+
+// SetWeekly replaces the time zone and the weekly hours of the store.
+func (m StoreHoursModel) SetWeekly(storeID int64, hours *StoreHours) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	weekdays := make([]int64, len(hours.Weekly))
+	opens := make([]int64, len(hours.Weekly))
+	closes := make([]int64, len(hours.Weekly))
+	for i, period := range hours.Weekly {
+		weekdays[i], opens[i], closes[i] = int64(period.Weekday), int64(period.Opens), int64(period.Closes)
+	}
+
+	return withTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `UPDATE stores SET time_zone = $1, updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $2 AND deleted_at IS NULL`, hours.TimeZone, storeID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrRecordNotFound
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM store_hours WHERE store_id = $1`, storeID)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO store_hours (store_id, weekday, opens_at, closes_at)
+			SELECT $1, * FROM unnest($2::smallint[], $3::smallint[], $4::smallint[])
+			`
+		_, err = tx.ExecContext(ctx, query, storeID, pq.Array(weekdays), pq.Array(opens), pq.Array(closes))
+		return err
+	})
+}
+
+// InsertClosure adds a special closure to the store.
+func (m StoreHoursModel) InsertClosure(closure *Closure) error {
+	query := `
+		INSERT INTO store_closures (store_id, starts_on, ends_on, reason)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, closure.StoreID, closure.StartsOn, closure.EndsOn, closure.Reason).Scan(&closure.ID, &closure.CreatedAt)
+	if err != nil && err.Error() == `pq: insert or update on table "store_closures" violates foreign key constraint "store_closures_store_id_fkey"` {
+		return ErrRecordNotFound
+	}
+
+	return err
+}
+
+// DeleteClosure removes a special closure from the store.
+func (m StoreHoursModel) DeleteClosure(storeID, id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM store_closures WHERE store_id = $1 AND id = $2`, storeID, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Schedules returns the schedules of the stores, keyed by store ID, for working out whether they
+// are open around now. Closures that ended before now are left out.
+func (m StoreHoursModel) Schedules(storeIDs []int64, now time.Time) (map[int64]*Schedule, error) {
+	if len(storeIDs) == 0 {
+		return map[int64]*Schedule{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Time zones are at most 14 hours off UTC, so two days back covers yesterday everywhere.
+	all, err := m.load(ctx, storeIDs, now.AddDate(0, 0, -2))
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make(map[int64]*Schedule, len(all))
+	for id, hours := range all {
+		schedules[id], err = NewSchedule(hours)
+		if err != nil {
+			return nil, fmt.Errorf("cannot build schedule of store %d: %w", id, err)
+		}
+	}
+
+	return schedules, nil
+}
+
+// load reads the hours of the stores, with the closures that end on or after the day of since.
+func (m StoreHoursModel) load(ctx context.Context, storeIDs []int64, since time.Time) (map[int64]*StoreHours, error) {
+	all := make(map[int64]*StoreHours, len(storeIDs))
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT id, time_zone FROM stores WHERE id = ANY($1)`, pq.Array(storeIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var hours StoreHours
+		if err := rows.Scan(&id, &hours.TimeZone); err != nil {
+			return nil, err
+		}
+		all[id] = &hours
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT store_id, weekday, opens_at, closes_at
+		FROM store_hours
+		WHERE store_id = ANY($1)
+		ORDER BY store_id, weekday, opens_at
+		`
+	rows, err = m.DB.QueryContext(ctx, query, pq.Array(storeIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var storeID int64
+		var period OpeningPeriod
+		if err := rows.Scan(&storeID, &period.Weekday, &period.Opens, &period.Closes); err != nil {
+			return nil, err
+		}
+		if hours, ok := all[storeID]; ok {
+			hours.Weekly = append(hours.Weekly, period)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT id, created_at, store_id, to_char(starts_on, 'YYYY-MM-DD'), to_char(ends_on, 'YYYY-MM-DD'), reason
+		FROM store_closures
+		WHERE store_id = ANY($1) AND ends_on >= $2::date
+		ORDER BY store_id, starts_on, id
+		`
+	rows, err = m.DB.QueryContext(ctx, query, pq.Array(storeIDs), since.UTC().Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var closure Closure
+		if err := rows.Scan(&closure.ID, &closure.CreatedAt, &closure.StoreID, &closure.StartsOn, &closure.EndsOn, &closure.Reason); err != nil {
+			return nil, err
+		}
+		if hours, ok := all[closure.StoreID]; ok {
+			hours.Closures = append(hours.Closures, &closure)
+		}
+	}
+
+	return all, rows.Err()
+}
+
+// ValidateStoreHours runs validation checks on the time zone and weekly hours of a store.
+func ValidateStoreHours(v *validator.Validator, hours *StoreHours) {
+	v.Check(hours.TimeZone != "", "timeZone", "must be provided")
+	if hours.TimeZone != "" {
+		_, err := time.LoadLocation(hours.TimeZone)
+		v.Check(err == nil && hours.TimeZone != "Local", "timeZone", "must be an IANA time zone name, such as Asia/Almaty")
+	}
+
+	validateWeekly(v, "weekly", hours.Weekly)
+}
+
+// validateWeekly checks that the weekly opening periods are valid and don't overlap.
+func validateWeekly(v *validator.Validator, key string, weekly []OpeningPeriod) {
+	v.Check(len(weekly) <= 50, key, "must not contain more than 50 periods")
+
+	for _, period := range weekly {
+		v.Check(period.Opens < minutesPerDay, key, "periods must not open at 24:00")
+		v.Check(period.Closes > 0, key, "periods must not close at 00:00, use 24:00 instead")
+		v.Check(period.Opens != period.Closes, key, "periods must not open and close at the same time")
+	}
+	if _, invalid := v.Errors[key]; invalid {
+		return
+	}
+
+	// Lay the periods out on the week and check that none starts before the previous one is over,
+	// including the one that runs from Saturday into Sunday.
+	periods := append([]OpeningPeriod(nil), weekly...)
+	start := func(p OpeningPeriod) int { return int(p.Weekday)*minutesPerDay + int(p.Opens) }
+	sort.Slice(periods, func(i, j int) bool { return start(periods[i]) < start(periods[j]) })
+
+	for i, period := range periods {
+		if i == len(periods)-1 && len(periods) > 1 {
+			v.Check(start(period)+period.minutes() <= start(periods[0])+minutesPerWeek, key, "periods must not overlap")
+		} else if i < len(periods)-1 {
+			v.Check(start(period)+period.minutes() <= start(periods[i+1]), key, "periods must not overlap")
+		}
+	}
+}
+
+// ValidateClosure runs validation checks on a special closure.
+func ValidateClosure(v *validator.Validator, closure *Closure) {
+	startsOn, err := time.Parse(dateLayout, closure.StartsOn)
+	v.Check(err == nil, "startsOn", "must be a date in the YYYY-MM-DD format")
+	endsOn, err := time.Parse(dateLayout, closure.EndsOn)
+	v.Check(err == nil, "endsOn", "must be a date in the YYYY-MM-DD format")
+
+	if v.Valid() {
+		v.Check(!endsOn.Before(startsOn), "endsOn", "must not be before startsOn")
+		v.Check(endsOn.Sub(startsOn) < scheduleHorizonDays*24*time.Hour, "endsOn", "closures must not be longer than a year")
+	}
+
+	v.Check(len(closure.Reason) <= 200, "reason", "must not be more than 200 bytes long")
+}
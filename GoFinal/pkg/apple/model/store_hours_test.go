@@ -0,0 +1,197 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// In Europe/Berlin the clocks go from 02:00 to 03:00 on 2024-03-31 and from 03:00 back to 02:00
+// on 2024-10-27, both at 01:00 UTC. A wall-clock time in the skipped hour is taken at the offset
+// from before the change, so 02:30 is 03:30 summer time, and one in the repeated hour at the
+// offset from after it, so 02:30 is the second one. Schedule and storeOpenSQL both have to do so.
+var dstCases = []struct {
+	name     string
+	weekly   []OpeningPeriod
+	closures []*Closure
+	at       string
+	open     bool
+}{
+	{
+		name:   "summer day",
+		weekly: []OpeningPeriod{{Weekday: Weekday(time.Monday), Opens: 9 * 60, Closes: 18 * 60}},
+		at:     "2024-07-01T08:00:00Z",
+		open:   true,
+	},
+	{
+		name:   "spring forward, before a period that opens in the gap",
+		weekly: []OpeningPeriod{{Weekday: Weekday(time.Sunday), Opens: 2*60 + 30, Closes: 4 * 60}},
+		at:     "2024-03-31T01:15:00Z",
+		open:   false,
+	},
+	{
+		name:   "spring forward, period that opened in the gap",
+		weekly: []OpeningPeriod{{Weekday: Weekday(time.Sunday), Opens: 2*60 + 30, Closes: 4 * 60}},
+		at:     "2024-03-31T01:45:00Z",
+		open:   true,
+	},
+	{
+		name:   "spring forward, at closing",
+		weekly: []OpeningPeriod{{Weekday: Weekday(time.Sunday), Opens: 2*60 + 30, Closes: 4 * 60}},
+		at:     "2024-03-31T02:00:00Z",
+		open:   false,
+	},
+	{
+		name:   "spring forward, overnight period",
+		weekly: []OpeningPeriod{{Weekday: Weekday(time.Saturday), Opens: 22 * 60, Closes: 6 * 60}},
+		at:     "2024-03-31T03:30:00Z",
+		open:   true,
+	},
+	{
+		name:   "spring forward, overnight period closed",
+		weekly: []OpeningPeriod{{Weekday: Weekday(time.Saturday), Opens: 22 * 60, Closes: 6 * 60}},
+		at:     "2024-03-31T04:00:00Z",
+		open:   false,
+	},
+	{
+		name:   "fall back, first 02:30 is before the opening",
+		weekly: []OpeningPeriod{{Weekday: Weekday(time.Sunday), Opens: 2*60 + 30, Closes: 4 * 60}},
+		at:     "2024-10-27T00:45:00Z",
+		open:   false,
+	},
+	{
+		name:   "fall back, second 02:30 is after the opening",
+		weekly: []OpeningPeriod{{Weekday: Weekday(time.Sunday), Opens: 2*60 + 30, Closes: 4 * 60}},
+		at:     "2024-10-27T01:45:00Z",
+		open:   true,
+	},
+	{
+		name:   "fall back, overnight period is an hour longer",
+		weekly: []OpeningPeriod{{Weekday: Weekday(time.Saturday), Opens: 22 * 60, Closes: 6 * 60}},
+		at:     "2024-10-27T04:30:00Z",
+		open:   true,
+	},
+	{
+		name:   "fall back, overnight period closed",
+		weekly: []OpeningPeriod{{Weekday: Weekday(time.Saturday), Opens: 22 * 60, Closes: 6 * 60}},
+		at:     "2024-10-27T05:00:00Z",
+		open:   false,
+	},
+	{
+		name:     "fall back, overnight period of a closed day",
+		weekly:   []OpeningPeriod{{Weekday: Weekday(time.Saturday), Opens: 22 * 60, Closes: 6 * 60}},
+		closures: []*Closure{{StartsOn: "2024-10-26", EndsOn: "2024-10-26", Reason: "Inventory"}},
+		at:       "2024-10-27T04:30:00Z",
+		open:     false,
+	},
+	{
+		name:     "fall back, closure of the next day doesn't end the overnight period",
+		weekly:   []OpeningPeriod{{Weekday: Weekday(time.Saturday), Opens: 22 * 60, Closes: 6 * 60}},
+		closures: []*Closure{{StartsOn: "2024-10-27", EndsOn: "2024-10-27", Reason: "Inventory"}},
+		at:       "2024-10-27T04:30:00Z",
+		open:     true,
+	},
+}
+
+func TestScheduleOpenAtDST(t *testing.T) {
+	for _, tc := range dstCases {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule, err := NewSchedule(&StoreHours{TimeZone: "Europe/Berlin", Weekly: tc.weekly, Closures: tc.closures})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			at, _ := time.Parse(time.RFC3339, tc.at)
+			if got := schedule.OpenAt(at); got != tc.open {
+				t.Errorf("OpenAt(%s) = %t, want %t", tc.at, got, tc.open)
+			}
+		})
+	}
+}
+
+func TestScheduleNextOpeningDST(t *testing.T) {
+	tests := []struct {
+		name   string
+		weekly []OpeningPeriod
+		from   string
+		want   string
+	}{
+		{
+			name:   "opens in the spring forward gap",
+			weekly: []OpeningPeriod{{Weekday: Weekday(time.Sunday), Opens: 2*60 + 30, Closes: 4 * 60}},
+			from:   "2024-03-31T00:00:00Z",
+			want:   "2024-03-31T01:30:00Z",
+		},
+		{
+			name:   "opens in the repeated fall back hour",
+			weekly: []OpeningPeriod{{Weekday: Weekday(time.Sunday), Opens: 2*60 + 30, Closes: 4 * 60}},
+			from:   "2024-10-27T00:00:00Z",
+			want:   "2024-10-27T01:30:00Z",
+		},
+		{
+			name:   "the week after the change",
+			weekly: []OpeningPeriod{{Weekday: Weekday(time.Monday), Opens: 9 * 60, Closes: 18 * 60}},
+			from:   "2024-10-27T12:00:00Z",
+			want:   "2024-10-28T08:00:00Z",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := NewSchedule(&StoreHours{TimeZone: "Europe/Berlin", Weekly: tt.weekly})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			from, _ := time.Parse(time.RFC3339, tt.from)
+			next := schedule.NextOpening(from)
+			if next == nil {
+				t.Fatalf("NextOpening(%s) = nil, want %s", tt.from, tt.want)
+			}
+			if got := next.UTC().Format(time.RFC3339); got != tt.want {
+				t.Errorf("NextOpening(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestStoreOpenSQLDST runs the same cases through the SQL condition the open_now filter uses, so
+// the openNow of a store can't contradict the filter.
+func TestStoreOpenSQLDST(t *testing.T) {
+	db := testDB(t)
+	models := NewModels(db)
+
+	for _, tc := range dstCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &Store{Title: "DST test", Description: tc.name, Address: "Alexanderplatz 1"}
+			if err := models.Stores.Insert(store, nil); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { mustExec(t, db, `DELETE FROM stores WHERE id = $1`, store.Id) })
+
+			storeID, _ := strconv.ParseInt(store.Id, 10, 64)
+
+			if err := models.StoreHours.SetWeekly(storeID, &StoreHours{TimeZone: "Europe/Berlin", Weekly: tc.weekly}); err != nil {
+				t.Fatal(err)
+			}
+			for _, closure := range tc.closures {
+				c := *closure
+				c.StoreID = storeID
+				if err := models.StoreHours.InsertClosure(&c); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			query := fmt.Sprintf(`SELECT %s FROM stores s WHERE s.id = $1`, storeOpenSQL("$2::timestamptz"))
+
+			var open bool
+			if err := db.QueryRow(query, storeID, tc.at).Scan(&open); err != nil {
+				t.Fatal(err)
+			}
+			if open != tc.open {
+				t.Errorf("storeOpenSQL(%s) = %t, want %t", tc.at, open, tc.open)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+	"github.com/lib/pq"
+)
+
+// StoreProduct is a single row of the stores_and_products table: it tells that a store carries
+// a product, or one variant of it, and how many items of it are in stock. Depending on the
+// direction of the lookup either the Product or the Store is embedded.
+type StoreProduct struct {
+	Id        string    `json:"id"`
+	CreatedAt string    `json:"createdAt"`
+	UpdatedAt string    `json:"updatedAt"`
+	StoreId   string    `json:"storeId"`
+	ProductId string    `json:"productId"`
+	VariantId *int64    `json:"variantId"`
+	Quantity  uint      `json:"quantity"`
+	Product   *Products `json:"product,omitempty"`
+	Store     *Store    `json:"store,omitempty"`
+}
+
+type StoreProductModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// GetAllForStore returns the assortment of a single store together with the product details.
+func (m StoreProductModel) GetAllForStore(storeID int, filters Filters) ([]*StoreProduct, Metadata, error) {
+	query := fmt.Sprintf(
+		`
+		SELECT count(*) OVER(), sp.id, sp.created_at, sp.updated_at, sp.store, sp.product, sp.variant_id, sp.quantity,
+			p.id, p.created_at, p.updated_at, p.title, p.description, p.countries, p.price, p.currency
+		FROM stores_and_products sp
+			INNER JOIN products p ON p.id = sp.product
+		WHERE sp.store = $1 AND p.deleted_at IS NULL
+		ORDER BY sp.%s %s, sp.id ASC
+		LIMIT $2 OFFSET $3
+		`,
+		filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, storeID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+
+	var items []*StoreProduct
+	for rows.Next() {
+		var sp StoreProduct
+		var prod Products
+		err := rows.Scan(&totalRecords, &sp.Id, &sp.CreatedAt, &sp.UpdatedAt, &sp.StoreId, &sp.ProductId, &sp.VariantId, &sp.Quantity,
+			&prod.Id, &prod.CreatedAt, &prod.UpdatedAt, &prod.Title, &prod.Description, pq.Array(&prod.Countries), &prod.Price.Amount, &prod.Price.Currency)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		sp.Product = &prod
+		items = append(items, &sp)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return items, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// GetAllForProduct returns every store that carries the product together with the store details.
+func (m StoreProductModel) GetAllForProduct(productID int, filters Filters) ([]*StoreProduct, Metadata, error) {
+	query := fmt.Sprintf(
+		`
+		SELECT count(*) OVER(), sp.id, sp.created_at, sp.updated_at, sp.store, sp.product, sp.variant_id, sp.quantity,
+			s.id, s.created_at, s.updated_at, s.title, s.description, s.address, s.latitude, s.longitude, s.number_of_branches, s.time_zone
+		FROM stores_and_products sp
+			INNER JOIN stores s ON s.id = sp.store
+		WHERE sp.product = $1 AND s.deleted_at IS NULL
+		ORDER BY sp.%s %s, sp.id ASC
+		LIMIT $2 OFFSET $3
+		`,
+		filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, productID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+
+	var items []*StoreProduct
+	for rows.Next() {
+		var sp StoreProduct
+		var store Store
+		err := rows.Scan(&totalRecords, &sp.Id, &sp.CreatedAt, &sp.UpdatedAt, &sp.StoreId, &sp.ProductId, &sp.VariantId, &sp.Quantity,
+			&store.Id, &store.CreatedAt, &store.UpdatedAt, &store.Title, &store.Description, &store.Address, &store.Latitude, &store.Longitude, &store.NumberOfBranches, &store.TimeZone)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		sp.Store = &store
+		items = append(items, &sp)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return items, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// GetAllForProducts returns the stores that carry each of the products together with the store
+// details, keyed by product ID. It embeds the stores into a page of products with one query.
+func (m StoreProductModel) GetAllForProducts(productIDs []string) (map[string][]*StoreProduct, error) {
+	query := `
+		SELECT sp.id, sp.created_at, sp.updated_at, sp.store, sp.product, sp.variant_id, sp.quantity,
+			s.id, s.created_at, s.updated_at, s.title, s.description, s.address, s.latitude, s.longitude, s.number_of_branches, s.time_zone
+		FROM stores_and_products sp
+			INNER JOIN stores s ON s.id = sp.store
+		WHERE sp.product = ANY($1::bigint[]) AND s.deleted_at IS NULL
+		ORDER BY sp.product, sp.id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(productIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	items := make(map[string][]*StoreProduct)
+	for rows.Next() {
+		var sp StoreProduct
+		var store Store
+		err := rows.Scan(&sp.Id, &sp.CreatedAt, &sp.UpdatedAt, &sp.StoreId, &sp.ProductId, &sp.VariantId, &sp.Quantity,
+			&store.Id, &store.CreatedAt, &store.UpdatedAt, &store.Title, &store.Description, &store.Address, &store.Latitude, &store.Longitude, &store.NumberOfBranches, &store.TimeZone)
+		if err != nil {
+			return nil, err
+		}
+
+		sp.Store = &store
+		items[sp.ProductId] = append(items[sp.ProductId], &sp)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// GetAllForStores returns the assortment of each of the stores together with the product
+// details, keyed by store ID. It embeds the products into a page of stores with one query.
+func (m StoreProductModel) GetAllForStores(storeIDs []string) (map[string][]*StoreProduct, error) {
+	query := `
+		SELECT sp.id, sp.created_at, sp.updated_at, sp.store, sp.product, sp.variant_id, sp.quantity,
+			p.id, p.created_at, p.updated_at, p.title, p.description, p.countries, p.price, p.currency
+		FROM stores_and_products sp
+			INNER JOIN products p ON p.id = sp.product
+		WHERE sp.store = ANY($1::bigint[]) AND p.deleted_at IS NULL
+		ORDER BY sp.store, sp.id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(storeIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	items := make(map[string][]*StoreProduct)
+	for rows.Next() {
+		var sp StoreProduct
+		var prod Products
+		err := rows.Scan(&sp.Id, &sp.CreatedAt, &sp.UpdatedAt, &sp.StoreId, &sp.ProductId, &sp.VariantId, &sp.Quantity,
+			&prod.Id, &prod.CreatedAt, &prod.UpdatedAt, &prod.Title, &prod.Description, pq.Array(&prod.Countries), &prod.Price.Amount, &prod.Price.Currency)
+		if err != nil {
+			return nil, err
+		}
+
+		sp.Product = &prod
+		items[sp.StoreId] = append(items[sp.StoreId], &sp)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Get returns the stock row of a product in a store, or ErrRecordNotFound if the store doesn't
+// carry the product. A variantID of 0 means the product itself rather than one of its variants.
+func (m StoreProductModel) Get(storeID, productID int, variantID int64) (*StoreProduct, error) {
+	if storeID < 1 || productID < 1 || variantID < 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, updated_at, store, product, variant_id, quantity
+		FROM stores_and_products
+		WHERE store = $1 AND product = $2 AND coalesce(variant_id, 0) = $3
+			AND store IN (SELECT id FROM stores WHERE deleted_at IS NULL)
+			AND product IN (SELECT id FROM products WHERE deleted_at IS NULL)
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sp StoreProduct
+	err := m.DB.QueryRowContext(ctx, query, storeID, productID, variantID).Scan(&sp.Id, &sp.CreatedAt, &sp.UpdatedAt, &sp.StoreId, &sp.ProductId, &sp.VariantId, &sp.Quantity)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &sp, nil
+}
+
+// Upsert puts the product, or the variant of it, into the store assortment, or replaces the
+// quantity if the store already carries it. It returns ErrRecordNotFound if the variant is not
+// one of the product.
+func (m StoreProductModel) Upsert(sp *StoreProduct) error {
+	query := `
+		INSERT INTO stores_and_products (store, product, variant_id, quantity)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (store, product, (coalesce(variant_id, 0))) DO UPDATE
+			SET quantity = EXCLUDED.quantity, updated_at = CURRENT_TIMESTAMP
+		RETURNING id, created_at, updated_at
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, sp.StoreId, sp.ProductId, sp.VariantId, sp.Quantity).Scan(&sp.Id, &sp.CreatedAt, &sp.UpdatedAt)
+	if err != nil && err.Error() == `pq: insert or update on table "stores_and_products" violates foreign key constraint "stores_and_products_variant_fkey"` {
+		return ErrRecordNotFound
+	}
+
+	return err
+}
+
+// Delete removes the product, or the variant of it, from the store assortment.
+func (m StoreProductModel) Delete(storeID, productID int, variantID int64) error {
+	if storeID < 1 || productID < 1 || variantID < 0 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM stores_and_products
+		WHERE store = $1 AND product = $2 AND coalesce(variant_id, 0) = $3
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, storeID, productID, variantID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func ValidateStoreProduct(v *validator.Validator, sp *StoreProduct) {
+	// Check if the quantity is in a sensible range.
+	v.Check(sp.Quantity <= 1_000_000, "quantity", "must not be more than 1000000")
+}
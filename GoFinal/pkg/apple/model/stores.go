@@ -3,50 +3,130 @@ package model
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/kim0111/GoMidterm/pkg/apple/validator"
 	"log"
+	"math"
+	"strconv"
 	"time"
 )
 
 type Store struct {
-	Id               string `json:"id"`
-	CreatedAt        string `json:"createdAt"`
-	UpdatedAt        string `json:"updatedAt"`
-	Title            string `json:"title"`
-	Description      string `json:"description"`
-	Address          string `json:"address"`
-	Coordinates      string `json:"coordinates"`
-	NumberOfBranches uint   `json:"numberOfBranches"`
+	Id          string `json:"id"`
+	CreatedAt   string `json:"createdAt"`
+	UpdatedAt   string `json:"updatedAt"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Address     string `json:"address"`
+	// Latitude and Longitude are the WGS 84 location of the store in degrees. Both are null for a
+	// store without a known location.
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	// NumberOfBranches is counted from the branches of the store and can't be set directly.
+	NumberOfBranches uint `json:"numberOfBranches"`
+	// TimeZone is the IANA time zone the opening hours of the store are in.
+	TimeZone string `json:"timeZone"`
+	// DistanceKm is the great-circle distance to the point a nearby search was made from.
+	DistanceKm *float64 `json:"distanceKm,omitempty"`
+	// OpenNow and NextOpening are worked out from the opening hours when the store is returned.
+	// NextOpening is left out when the store doesn't open within a year.
+	OpenNow     *bool      `json:"openNow,omitempty"`
+	NextOpening *time.Time `json:"nextOpening,omitempty"`
+	// Highlight holds the matched snippets when the store was found by a full-text search.
+	Highlight *Highlight `json:"highlight,omitempty"`
+	// Products holds the assortment of the store when the client asked to include it.
+	Products []*StoreProduct `json:"products,omitempty"`
+	// Version goes up with every change of the store. It's sent as the ETag instead of in the
+	// body.
+	Version int `json:"-"`
 }
 
+// StoreQuery holds the filters of a store listing. The zero value of a field means "don't filter".
+type StoreQuery struct {
+	Title        string
+	BranchesFrom int
+	BranchesTo   int
+	// Query is a full-text search over the title, description and address, in web search syntax
+	// (quoted phrases, "or" and -excluded words).
+	Query string
+	// OpenNow keeps only the stores that are open (true) or closed (false) at OpenAt.
+	OpenNow *bool
+	OpenAt  time.Time
+}
+
+const (
+	// earthRadiusKm is the mean radius of the Earth.
+	earthRadiusKm = 6371.0088
+	// kmPerDegree is a little less than the length of a degree of latitude, so the latitude band
+	// of a nearby search is never too narrow.
+	kmPerDegree = 111.0
+	// maxRadiusKm is the largest radius of a nearby search, about half the circumference of the
+	// Earth, which already covers every point.
+	maxRadiusKm = 20000
+)
+
 type StoreModel struct {
 	DB       *sql.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
 }
 
-func (s StoreModel) GetAll(title string, from, to int, filters Filters) ([]*Store, Metadata, error) {
+// where returns the WHERE condition of the store list and its arguments. The condition uses the
+// placeholders $1 to $6, with the full-text search query always being $4, so a query can append
+// its own arguments after them. It expects the stores table to be aliased as "s".
+func (q StoreQuery) where() (string, []interface{}) {
+	where := fmt.Sprintf(`deleted_at IS NULL
+			AND (LOWER(title) = LOWER($1) OR $1 = '')
+			AND (number_of_branches >= $2 OR $2 = 0)
+			AND (number_of_branches <= $3 OR $3 = 0)
+			AND (search @@ websearch_to_tsquery('%s', $4) OR $4 = '')
+			AND ($6::boolean IS NULL OR %s = $6)`, searchConfig, storeOpenSQL("$5::timestamptz"))
+
+	return where, []interface{}{q.Title, q.BranchesFrom, q.BranchesTo, q.Query, q.OpenAt, q.OpenNow}
+}
+
+func (s StoreModel) GetAll(q StoreQuery, filters Filters) ([]*Store, Metadata, error) {
+	where, args := q.where()
+	whereArgs := args
+	keyset, keysetArgs := filters.keyset(len(args) + 1)
+	args = append(args, keysetArgs...)
+
+	// Retrieve all stores items from the database. The page is selected in the inner query, so
+	// the costly highlighting is only done for the rows that are actually returned. The matching
+	// stores are selected in a query of their own, so the page can be selected by their rank.
+	// Only the columns of the fields asked for are read, plus the ones the page is selected by.
+	columns := storeColumns.selected(filters.Fields, "id", filters.sortKey())
 
-	// Retrieve all stores items from the database.
 	query := fmt.Sprintf(
 		`
-		SELECT count(*) OVER(), id, created_at, updated_at, title, description, address, coordinates, number_of_branches
-		FROM stores
-		WHERE (LOWER(title) = LOWER($1) OR $1 = '')
-		AND (number_of_branches >= $2 OR $2 = 0)
-		AND (number_of_branches <= $3 OR $3 = 0)
-		ORDER BY %s %s, id ASC
-		LIMIT $4 OFFSET $5
+		SELECT total, %[11]s, sort_key,
+			CASE WHEN $4 = '' THEN '' ELSE ts_headline('%[2]s', headline_title, websearch_to_tsquery('%[2]s', $4), '%[3]s') END,
+			CASE WHEN $4 = '' THEN '' ELSE ts_headline('%[2]s', headline_description, websearch_to_tsquery('%[2]s', $4), '%[4]s') END
+		FROM (
+			SELECT %[8]s AS total, %[11]s, search_rank,
+				%[9]s::text AS sort_key, title AS headline_title, description AS headline_description
+			FROM (
+				SELECT s.*,
+					CASE WHEN $4 = '' THEN 0 ELSE ts_rank(search, websearch_to_tsquery('%[2]s', $4)) END AS search_rank
+				FROM stores s
+				WHERE %[5]s
+			) AS matched
+			WHERE %[10]s
+			ORDER BY %[1]s
+			LIMIT $%[6]d OFFSET $%[7]d
+		) AS page
+		ORDER BY %[1]s
 		`,
-		filters.sortColumn(), filters.sortDirection())
+		filters.pageOrderBy(), searchConfig, titleHeadlineOptions, descriptionHeadlineOptions, where, len(args)+1, len(args)+2,
+		filters.total(), filters.sortKey(), keyset, selectList(columns))
 
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// Organize our four placeholder parameter values in a slice.
-	args := []interface{}{title, from, to, filters.limit(), filters.offset()}
+	// Organize our placeholder parameter values in a slice.
+	args = append(args, filters.limit(), filters.offset())
 
 	// log.Println(query, title, from, to, filters.limit(), filters.offset())
 	// Use QueryContext to execute the query. This returns a sql.Rows result set containing
@@ -68,12 +148,22 @@ func (s StoreModel) GetAll(title string, from, to int, filters Filters) ([]*Stor
 	totalRecords := 0
 
 	var stores []*Store
+	var keys []cursorKey
 	for rows.Next() {
 		var store Store
-		err := rows.Scan(&totalRecords, &store.Id, &store.CreatedAt, &store.UpdatedAt, &store.Title, &store.Description, &store.Address, &store.Coordinates, &store.NumberOfBranches)
+		var highlight Highlight
+		var key cursorKey
+		dest := append([]interface{}{&totalRecords}, scanDest(columns, &store)...)
+		err := rows.Scan(append(dest, &key.value, &highlight.Title, &highlight.Description)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+		key.id = store.Id
+		keys = append(keys, key)
+
+		if q.Query != "" {
+			store.Highlight = highlight.html()
+		}
 
 		// Add the Movie struct to the slice
 		stores = append(stores, &store)
@@ -85,78 +175,230 @@ func (s StoreModel) GetAll(title string, from, to int, filters Filters) ([]*Stor
 		return nil, Metadata{}, err
 	}
 
+	if filters.Cursor != nil {
+		return cursorPage(filters, stores, keys)
+	}
+
 	// Generate a Metadata struct, passing in the total record count and pagination parameters
 	// from the client.
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	metadata, err := pageMetadata(ctx, s.DB, "stores s", where, whereArgs, filters, totalRecords, len(stores))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
 
 	// If everything went OK, then return the slice of the movies and metadata.
 	return stores, metadata, nil
 }
 
-func (p StoreModel) Insert(store *Store) error {
+// Insert creates the store and records it in the audit log with the given entry, if any.
+func (p StoreModel) Insert(store *Store, audit *AuditEntry) error {
 	query := `
-		INSERT INTO stores (title, description, address, coordinates, number_of_branches) 
-		VALUES ($1, $2, $3, $4, $5) 
-		RETURNING id, created_at, updated_at
+		INSERT INTO stores (title, description, address, latitude, longitude, number_of_branches) 
+		VALUES ($1, $2, $3, $4, $5, 0) 
+		RETURNING id, created_at, updated_at, time_zone, number_of_branches, version
 		`
-	args := []interface{}{store.Title, store.Description, store.Address, store.Coordinates, store.NumberOfBranches}
+	args := []interface{}{store.Title, store.Description, store.Address, store.Latitude, store.Longitude}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return p.DB.QueryRowContext(ctx, query, args...).Scan(&store.Id, &store.CreatedAt, &store.UpdatedAt)
+	return withTx(ctx, p.DB, nil, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&store.Id, &store.CreatedAt, &store.UpdatedAt, &store.TimeZone, &store.NumberOfBranches, &store.Version)
+		if err != nil {
+			return err
+		}
+		return audit.record(ctx, tx, store.Id, store)
+	})
 }
 
 func (s StoreModel) Get(id int) (*Store, error) {
+	return s.GetFields(id, nil)
+}
+
+// GetFields returns the store with only the given fields read, or all of them if fields is empty.
+// The ID is always read.
+func (s StoreModel) GetFields(id int, fields []string) (*Store, error) {
 	// Return an error if the ID is less than 1.
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
+	columns := storeColumns.selected(fields, "id", "version")
+
 	query := `
-		SELECT id, created_at, updated_at, title, description, address, coordinates, number_of_branches
+		SELECT ` + selectList(columns) + `
 		FROM stores
-		WHERE id = $1
+		WHERE id = $1 AND deleted_at IS NULL
 		`
 	var store Store
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	row := s.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&store.Id, &store.CreatedAt, &store.UpdatedAt, &store.Title, &store.Description, &store.Address, &store.Coordinates, &store.NumberOfBranches)
+	err := row.Scan(scanDest(columns, &store)...)
 	if err != nil {
-		return nil, fmt.Errorf("cannot retrive store with id: %v, %w", id, err)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, fmt.Errorf("cannot retrive store with id: %v, %w", id, err)
+		}
 	}
 	return &store, nil
 }
 
-func (s StoreModel) Update(store *Store) error {
+// Update saves the store. It returns ErrEditConflict if the store is no longer at the version it
+// was read at, and ErrRecordNotFound if it was deleted in the meantime. The change is recorded in
+// the audit log with the given entry, if any.
+func (s StoreModel) Update(store *Store, audit *AuditEntry) error {
 	query := `
 		UPDATE stores
-		SET title = $1, description = $2, address = $3, coordinates = &4, number_of_branches = &5, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $6 AND updated_at = $7
-		RETURNING updated_at
+		SET title = $1, description = $2, address = $3, latitude = $4, longitude = $5, updated_at = CURRENT_TIMESTAMP,
+			version = version + 1
+		WHERE id = $6 AND version = $7 AND deleted_at IS NULL
+		RETURNING updated_at, number_of_branches, version
 		`
-	args := []interface{}{store.Title, store.Description, store.Address, store.Coordinates, store.NumberOfBranches, store.Id, store.UpdatedAt}
+	args := []interface{}{store.Title, store.Description, store.Address, store.Latitude, store.Longitude, store.Id, store.Version}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return s.DB.QueryRowContext(ctx, query, args...).Scan(&store.UpdatedAt)
+	return withTx(ctx, s.DB, nil, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&store.UpdatedAt, &store.NumberOfBranches, &store.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return versionMismatch(ctx, tx, "stores", store.Id)
+			default:
+				return err
+			}
+		}
+		return audit.record(ctx, tx, store.Id, store)
+	})
+}
+
+// Nearby returns the stores within radiusKm kilometres of the given point, closest first, with
+// their distance to it. Stores without a location are never returned.
+func (s StoreModel) Nearby(lat, lon, radiusKm float64, filters Filters) ([]*Store, Metadata, error) {
+	// The distance is the haversine great-circle distance. A degree of latitude is always about
+	// 111 km, so the latitude band rules out most stores before any distance is computed.
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, updated_at, title, description, address, latitude, longitude, number_of_branches, time_zone, distance
+		FROM (
+			SELECT id, created_at, updated_at, title, description, address, latitude, longitude, number_of_branches, time_zone,
+				2 * %[1]v * asin(least(1, sqrt(
+					power(sin(radians(latitude - $1::double precision) / 2), 2) +
+					cos(radians($1::double precision)) * cos(radians(latitude)) * power(sin(radians(longitude - $2::double precision) / 2), 2)
+				))) AS distance
+			FROM stores
+			WHERE deleted_at IS NULL AND latitude BETWEEN $1::double precision - $3::double precision / %[2]v AND $1::double precision + $3::double precision / %[2]v
+		) AS located
+		WHERE distance <= $3::double precision
+		ORDER BY distance ASC, id ASC
+		LIMIT $4 OFFSET $5
+		`, earthRadiusKm, kmPerDegree)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, lat, lon, radiusKm, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+
+	var stores []*Store
+	for rows.Next() {
+		var store Store
+		var distance float64
+		err := rows.Scan(&totalRecords, &store.Id, &store.CreatedAt, &store.UpdatedAt, &store.Title, &store.Description, &store.Address, &store.Latitude, &store.Longitude, &store.NumberOfBranches, &store.TimeZone,
+			&distance)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		distance = math.Round(distance*1000) / 1000
+		store.DistanceKm = &distance
+		stores = append(stores, &store)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return stores, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
 }
 
-func (p StoreModel) Delete(id int) error {
+// Delete moves the store to the trash, the same way ProductModel.Delete does.
+func (p StoreModel) Delete(id, version int, audit *AuditEntry) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-		DELETE FROM stores
-		WHERE id = $1
+		UPDATE stores
+		SET deleted_at = CURRENT_TIMESTAMP, version = version + 1
+		WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+		`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, p.DB, nil, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, id, version)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return versionMismatch(ctx, tx, "stores", id)
+		}
+
+		return audit.record(ctx, tx, strconv.Itoa(id), nil)
+	})
+}
+
+// Restore takes the store out of the trash. It returns ErrRecordNotFound if the store is not in
+// the trash. The restore is recorded in the audit log with the given entry, if any.
+func (s StoreModel) Restore(id int, audit *AuditEntry) (*Store, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE stores
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING ` + selectList(storeColumns.columns) + `
 		`
+	var store Store
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := p.DB.ExecContext(ctx, query, id)
-	return err
+	err := withTx(ctx, s.DB, nil, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, id).Scan(scanDest(storeColumns.columns, &store)...)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+		return audit.record(ctx, tx, store.Id, &store)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &store, nil
 }
 
 func ValidateStore(v *validator.Validator, store *Store) {
@@ -168,6 +410,30 @@ func ValidateStore(v *validator.Validator, store *Store) {
 	v.Check(len(store.Description) <= 1000, "description", "must not be more than 1000 bytes long")
 	// Check if the forWhatCountry is not more than 10 characters.
 	v.Check(len(store.Address) <= 500, "Address", "must not be more than 500 bytes long")
-	v.Check(len(store.Coordinates) <= 100, "Address", "must not be more than 100 bytes long")
-	v.Check(store.NumberOfBranches <= 500, "numberOfBranches", "must not be more than 1000")
+	// Check if the location is complete and within the valid ranges.
+	v.Check((store.Latitude == nil) == (store.Longitude == nil), "latitude", "must be provided together with longitude")
+	if store.Latitude != nil {
+		ValidateLatitude(v, "latitude", *store.Latitude)
+	}
+	if store.Longitude != nil {
+		ValidateLongitude(v, "longitude", *store.Longitude)
+	}
+}
+
+// ValidateNearbyQuery runs validation checks on the point and radius of a nearby search.
+func ValidateNearbyQuery(v *validator.Validator, lat, lon, radiusKm float64) {
+	ValidateLatitude(v, "lat", lat)
+	ValidateLongitude(v, "lon", lon)
+	v.Check(radiusKm > 0, "radius_km", "must be greater than zero")
+	v.Check(radiusKm <= maxRadiusKm, "radius_km", fmt.Sprintf("must not be more than %d", maxRadiusKm))
+}
+
+// ValidateLatitude checks that a latitude is within -90 and 90 degrees.
+func ValidateLatitude(v *validator.Validator, key string, lat float64) {
+	v.Check(lat >= -90 && lat <= 90, key, "must be between -90 and 90")
+}
+
+// ValidateLongitude checks that a longitude is within -180 and 180 degrees.
+func ValidateLongitude(v *validator.Validator, key string, lon float64) {
+	v.Check(lon >= -180 && lon <= 180, key, "must be between -180 and 180")
 }
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+// purgeTimeout bounds a purge, which deletes every expired product and store at once.
+const purgeTimeout = 30 * time.Second
+
+// TrashItem is a deleted product or store waiting in the trash to be restored or purged.
+type TrashItem struct {
+	Type      string    `json:"type"` // "product" or "store"
+	ID        int64     `json:"id"`
+	Title     string    `json:"title"`
+	DeletedAt time.Time `json:"deletedAt"`
+	// PurgeAt is when the item is deleted for good, if the trash is purged at all.
+	PurgeAt *time.Time `json:"purgeAt,omitempty"`
+}
+
+// PurgeResult is the outcome of a purge.
+type PurgeResult struct {
+	Products int
+	Stores   int
+	// Images are the images of the purged products. Their rows are gone, their files have to be
+	// removed separately.
+	Images []*ProductImage
+}
+
+type TrashModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// GetAll returns the products and stores in the trash. itemType limits them to "product" or
+// "store", an empty itemType returns both.
+func (m TrashModel) GetAll(itemType string, filters Filters) ([]*TrashItem, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), type, id, title, deleted_at
+		FROM (
+			SELECT 'product' AS type, id, title, deleted_at
+			FROM products
+			WHERE deleted_at IS NOT NULL AND $1 IN ('', 'product')
+			UNION ALL
+			SELECT 'store' AS type, id, title, deleted_at
+			FROM stores
+			WHERE deleted_at IS NOT NULL AND $1 IN ('', 'store')
+		) AS trash
+		ORDER BY %s, type ASC, id ASC
+		LIMIT $2 OFFSET $3
+		`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, itemType, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+
+	items := []*TrashItem{}
+	for rows.Next() {
+		var item TrashItem
+		if err := rows.Scan(&totalRecords, &item.Type, &item.ID, &item.Title, &item.DeletedAt); err != nil {
+			return nil, Metadata{}, err
+		}
+
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return items, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Purge deletes the products and stores that were moved to the trash before the given time for
+// good, together with everything that belongs to them. Orders keep their lines, which lose the
+// link to the purged product or store.
+func (m TrashModel) Purge(before time.Time) (*PurgeResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), purgeTimeout)
+	defer cancel()
+
+	result := &PurgeResult{}
+
+	err := withTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT storage_key, thumbnail_key
+			FROM product_images
+			WHERE product_id IN (SELECT id FROM products WHERE deleted_at < $1)
+			`, before)
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			var image ProductImage
+			if err := rows.Scan(&image.StorageKey, &image.ThumbnailKey); err != nil {
+				rows.Close()
+				return err
+			}
+			result.Images = append(result.Images, &image)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		result.Products, err = purgeTable(ctx, tx, "products", before)
+		if err != nil {
+			return err
+		}
+
+		result.Stores, err = purgeTable(ctx, tx, "stores", before)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// purgeTable deletes the rows of the table that were moved to the trash before the given time.
+func purgeTable(ctx context.Context, tx *sql.Tx, table string, before time.Time) (int, error) {
+	result, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE deleted_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	return int(rowsAffected), err
+}
+
+// ValidateTrashType checks the type the trash is filtered by.
+func ValidateTrashType(v *validator.Validator, itemType string) {
+	v.Check(validator.In(itemType, "", "product", "store"), "type", "must be product or store")
+}
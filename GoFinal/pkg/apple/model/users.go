@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"errors"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/kim0111/GoMidterm/pkg/apple/validator"
@@ -85,7 +86,8 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 // created_at, and version fields are all automatically generated by our database, so we use use
 // the RETURNING clause to read them into the User struct after the insert. Also, we check
 // if our table already contains the same email address and if so return ErrDuplicateEmail error.
-func (m UserModel) Insert(user *User) error {
+// The new user is recorded in the audit log with the given entry, if any.
+func (m UserModel) Insert(user *User, audit *AuditEntry) error {
 	query := `
 		INSERT INTO users (name, email, password_hash, activated)
 		VALUES ($1, $2, $3, $4)
@@ -102,17 +104,19 @@ func (m UserModel) Insert(user *User) error {
 	// that we set up in the previous chapter. We check for this error specifically, and return
 	// ErrDuplicateEmail error instead.
 	pqErr := `pq: duplicate key value violates unique constraint "users_email_key"`
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
-	if err != nil {
-		switch {
-		case err.Error() == pqErr:
-			return ErrDuplicateEmail
-		default:
-			return err
+	return withTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+		if err != nil {
+			switch {
+			case err.Error() == pqErr:
+				return ErrDuplicateEmail
+			default:
+				return err
+			}
 		}
-	}
 
-	return nil
+		return audit.record(ctx, tx, strconv.FormatInt(user.ID, 10), user)
+	})
 }
 
 // GetByEmail retrieves the User details from the database based on the user's email address.
@@ -154,8 +158,9 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 
 // Update updates the details for a specific user in the users table. Note, we check against the
 // version field to help prevent any race conditions during the request cycle. Also, we check
-// for a violation of the "user_email_key" constraint.
-func (m UserModel) Update(user *User) error {
+// for a violation of the "user_email_key" constraint. The change is recorded in the audit log with
+// the given entry, if any.
+func (m UserModel) Update(user *User, audit *AuditEntry) error {
 	query := `
 		UPDATE users
 		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -175,19 +180,21 @@ func (m UserModel) Update(user *User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
-	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
-		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
-		default:
-			return err
+	return withTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+				return ErrDuplicateEmail
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
 		}
-	}
 
-	return nil
+		return audit.record(ctx, tx, strconv.FormatInt(user.ID, 10), user)
+	})
 }
 
 // GetForToken retrieves a user record from the users table for an associated token and token scope.
@@ -0,0 +1,286 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/kim0111/GoMidterm/pkg/apple/validator"
+)
+
+var (
+	// ErrDuplicateSKU is returned when another variant already has the SKU.
+	ErrDuplicateSKU = errors.New("duplicate sku")
+
+	// ErrDuplicateOptions is returned when another variant of the same product already has the
+	// same option values.
+	ErrDuplicateOptions = errors.New("duplicate variant options")
+)
+
+// Variant is a concrete version of a product with its own SKU, such as the 256 GB black
+// "iPhone 17 pro MAX". Options holds the values that tell the variants apart, e.g.
+// {"color": "black", "storage": "256GB"}.
+type Variant struct {
+	ID        int64             `json:"id"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+	ProductID int64             `json:"productId"`
+	SKU       string            `json:"sku"`
+	Options   map[string]string `json:"options"`
+	// PriceOverride replaces the product price for this variant, if set.
+	PriceOverride *Money `json:"priceOverride"`
+	// Price is the price the variant is sold for: the override or else the product price.
+	Price Money `json:"price"`
+	// Stock is the number of items in stock over all stores.
+	Stock int `json:"stock"`
+}
+
+type VariantModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// variantColumns selects a variant together with the product price and the total stock. It's
+// scanned by scanVariant.
+const variantColumns = `
+	v.id, v.created_at, v.updated_at, v.product_id, v.sku, v.options, v.price, v.currency, p.price, p.currency,
+	coalesce((SELECT sum(sp.quantity) FROM stores_and_products sp WHERE sp.variant_id = v.id), 0)`
+
+func scanVariant(row interface{ Scan(...interface{}) error }) (*Variant, error) {
+	var variant Variant
+	var options []byte
+	var price sql.NullInt64
+	var currency sql.NullString
+
+	err := row.Scan(&variant.ID, &variant.CreatedAt, &variant.UpdatedAt, &variant.ProductID, &variant.SKU, &options,
+		&price, &currency, &variant.Price.Amount, &variant.Price.Currency, &variant.Stock)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(options, &variant.Options); err != nil {
+		return nil, err
+	}
+
+	if price.Valid && currency.Valid {
+		variant.PriceOverride = &Money{Amount: price.Int64, Currency: currency.String}
+		variant.Price = *variant.PriceOverride
+	}
+
+	return &variant, nil
+}
+
+// GetAllForProduct returns every variant of the product ordered by ID.
+func (m VariantModel) GetAllForProduct(productID int64) ([]*Variant, error) {
+	query := `
+		SELECT ` + variantColumns + `
+		FROM product_variants v
+			INNER JOIN products p ON p.id = v.product_id
+		WHERE v.product_id = $1 AND p.deleted_at IS NULL
+		ORDER BY v.id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	variants := []*Variant{}
+	for rows.Next() {
+		variant, err := scanVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		variants = append(variants, variant)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+// Get returns a variant of the product.
+func (m VariantModel) Get(productID, id int64) (*Variant, error) {
+	if productID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + variantColumns + `
+		FROM product_variants v
+			INNER JOIN products p ON p.id = v.product_id
+		WHERE v.product_id = $1 AND v.id = $2 AND p.deleted_at IS NULL
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	variant, err := scanVariant(m.DB.QueryRowContext(ctx, query, productID, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return variant, nil
+}
+
+// Insert adds a variant to its product.
+func (m VariantModel) Insert(variant *Variant) error {
+	options, err := json.Marshal(variant.Options)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO product_variants (product_id, sku, options, price, currency)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at
+		`
+	price, currency := variant.priceOverrideArgs()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, variant.ProductID, variant.SKU, options, price, currency).Scan(&variant.ID, &variant.CreatedAt, &variant.UpdatedAt)
+	return variantError(err)
+}
+
+// Update saves the SKU, options and price override of the variant.
+func (m VariantModel) Update(variant *Variant) error {
+	options, err := json.Marshal(variant.Options)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE product_variants
+		SET sku = $1, options = $2, price = $3, currency = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE product_id = $5 AND id = $6
+		RETURNING updated_at
+		`
+	price, currency := variant.priceOverrideArgs()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, variant.SKU, options, price, currency, variant.ProductID, variant.ID).Scan(&variant.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+
+	return variantError(err)
+}
+
+// Delete removes a variant of the product together with its stock in every store.
+func (m VariantModel) Delete(productID, id int64) error {
+	if productID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM product_variants
+		WHERE product_id = $1 AND id = $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, productID, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// variantKey returns the ID of an optional variant the way the stock rows are matched on it:
+// 0 stands for the product itself.
+func variantKey(variantID *int64) int64 {
+	if variantID == nil {
+		return 0
+	}
+
+	return *variantID
+}
+
+// priceOverrideArgs returns the price and currency column values of the variant, which are both
+// NULL without a price override.
+func (variant *Variant) priceOverrideArgs() (sql.NullInt64, sql.NullString) {
+	if variant.PriceOverride == nil {
+		return sql.NullInt64{}, sql.NullString{}
+	}
+
+	return sql.NullInt64{Int64: variant.PriceOverride.Amount, Valid: true},
+		sql.NullString{String: variant.PriceOverride.Currency, Valid: true}
+}
+
+// variantError translates the constraint violations of the product_variants table into the errors
+// of this package.
+func variantError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch err.Error() {
+	case `pq: duplicate key value violates unique constraint "product_variants_sku_key"`:
+		return ErrDuplicateSKU
+	case `pq: duplicate key value violates unique constraint "product_variants_product_id_options_key"`:
+		return ErrDuplicateOptions
+	case `pq: insert or update on table "product_variants" violates foreign key constraint "product_variants_product_id_fkey"`:
+		return ErrRecordNotFound
+	default:
+		return err
+	}
+}
+
+// skuRX matches SKUs made of letters, digits and the separators "-", "_" and ".".
+var skuRX = regexp.MustCompile(`^[A-Za-z0-9]+([-_.][A-Za-z0-9]+)*$`)
+
+// ValidateVariant checks the variant of the given product. A price override has to be in the
+// currency of the product, so a checkout never mixes currencies because of a variant.
+func ValidateVariant(v *validator.Validator, variant *Variant, product *Products) {
+	v.Check(variant.SKU != "", "sku", "must be provided")
+	v.Check(len(variant.SKU) <= 64, "sku", "must not be more than 64 bytes long")
+	v.Check(variant.SKU == "" || validator.Matches(variant.SKU, skuRX), "sku", "must only contain letters, digits, '-', '_' and '.'")
+
+	v.Check(len(variant.Options) > 0, "options", "must contain at least 1 option")
+	v.Check(len(variant.Options) <= 5, "options", "must not contain more than 5 options")
+	for name, value := range variant.Options {
+		v.Check(name != "" && len(name) <= 30, "options", "must only contain names of 1 to 30 bytes")
+		v.Check(value != "" && len(value) <= 50, "options", "must only contain values of 1 to 50 bytes")
+	}
+
+	if variant.PriceOverride != nil {
+		ValidateMoney(v, "priceOverride", *variant.PriceOverride, 1_000_000)
+		v.Check(variant.PriceOverride.Currency == product.Price.Currency, "priceOverride", "must be in the product currency "+product.Price.Currency)
+	}
+}
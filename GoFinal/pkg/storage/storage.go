@@ -0,0 +1,33 @@
+// Package storage keeps uploaded files, such as product images, behind a small interface, so the
+// local filesystem can be swapped for an object store without touching the handlers.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when there is no file stored under a key.
+var ErrNotFound = errors.New("storage: file not found")
+
+// ErrInvalidKey is returned for keys that are not clean, relative, slash-separated paths.
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// Storage stores files under keys such as "products/1/3f2a9c.jpg".
+type Storage interface {
+	// Put stores the content of r under key, replacing any file stored under it before.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns the file stored under key or ErrNotFound.
+	Open(ctx context.Context, key string) (File, error)
+	// Delete removes the file stored under key. Deleting a missing file is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// File is an opened stored file.
+type File interface {
+	io.ReadSeekCloser
+	// ModTime returns the time the file was stored.
+	ModTime() time.Time
+}
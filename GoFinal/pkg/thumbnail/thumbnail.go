@@ -0,0 +1,58 @@
+// Package thumbnail scales images down using only the standard library image packages.
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+)
+
+// Fit returns a copy of img scaled down to fit into a size x size square, keeping the aspect
+// ratio. Images that already fit are copied unchanged. Every target pixel is the average of the
+// source pixels it covers (box filter), which is good enough for thumbnails and much better than
+// nearest neighbour sampling. The colors are averaged premultiplied by alpha, so transparent
+// pixels don't bleed into their neighbours.
+func Fit(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, max(1, h*size/w)
+		} else {
+			tw, th = max(1, w*size/h), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		sy0 := b.Min.Y + y*h/th
+		sy1 := max(sy0+1, b.Min.Y+(y+1)*h/th)
+
+		for x := 0; x < tw; x++ {
+			sx0 := b.Min.X + x*w/tw
+			sx1 := max(sx0+1, b.Min.X+(x+1)*w/tw)
+
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					c := color.RGBA64Model.Convert(img.At(sx, sy)).(color.RGBA64)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					bl += uint64(c.B)
+					a += uint64(c.A)
+					n++
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	return dst
+}